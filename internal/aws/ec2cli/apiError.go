@@ -0,0 +1,107 @@
+package ec2cli
+
+import (
+	"context"
+	"strings"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/retry"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// error codes of EC2 API, see https://docs.aws.amazon.com/AWSEC2/latest/APIReference/errors-overview.html
+var apiErrorKinds = map[string]tscerr.Kind{
+	"AuthFailure":                        tscerr.Auth,
+	"UnauthorizedOperation":              tscerr.Auth,
+	"InvalidClientTokenId":               tscerr.Auth,
+	"SignatureDoesNotMatch":              tscerr.Auth,
+	"OptInRequired":                      tscerr.Auth,
+	"Blocked":                            tscerr.Auth,
+	"AccessDenied":                       tscerr.Auth,
+	"ExpiredToken":                       tscerr.Auth,
+	"ExpiredTokenException":              tscerr.Auth,
+	"InsufficientInstanceCapacity":       tscerr.Capacity,
+	"InsufficientHostCapacity":           tscerr.Capacity,
+	"VcpuLimitExceeded":                  tscerr.Capacity,
+	"InstanceLimitExceeded":              tscerr.Capacity,
+	"SecurityGroupLimitExceeded":         tscerr.Capacity,
+	"RulesPerSecurityGroupLimitExceeded": tscerr.Capacity,
+	"KeyPairLimitExceeded":               tscerr.Capacity,
+	"Unsupported":                        tscerr.Capacity,
+	"InvalidParameter":                   tscerr.BadInput,
+	"InvalidParameterValue":              tscerr.BadInput,
+	"InvalidParameterCombination":        tscerr.BadInput,
+	"InvalidAMIID.Malformed":             tscerr.BadInput,
+	"InvalidKeyPair.Duplicate":           tscerr.BadInput,
+	"InvalidGroup.Duplicate":             tscerr.BadInput,
+	"RequestExpired":                     tscerr.Timeout,
+}
+
+// error codes of throttled requests and internal failures of EC2 API, the same request succeeds later
+var retryableCodes = map[string]bool{
+	"RequestLimitExceeded": true,
+	"Throttling":           true,
+	"ThrottlingException":  true,
+	"RequestThrottled":     true,
+	"InternalError":        true,
+	"InternalFailure":      true,
+	"ServiceUnavailable":   true,
+	"Unavailable":          true,
+}
+
+// APIErrorCode returns EC2 API error code from err chain, empty if err is not an API error
+func APIErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func apiErrorKind(err error) tscerr.Kind {
+	code := APIErrorCode(err)
+	if kind, ok := apiErrorKinds[code]; ok {
+		return kind
+	}
+	// e.g. InvalidAMIID.NotFound, InvalidInstanceID.NotFound, InvalidGroup.NotFound
+	if strings.HasSuffix(code, ".NotFound") {
+		return tscerr.NotFound
+	}
+	if errors.Is(err, context.Canceled) {
+		return tscerr.Interrupted
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return tscerr.Timeout
+	}
+	// credentials were not found or role was not assumed, request was not sent
+	var signErr *v4.SigningError
+	if errors.As(err, &signErr) {
+		return tscerr.Auth
+	}
+	return tscerr.Unknown
+}
+
+// wrap wraps error returned by EC2 API with its kind
+func wrap(err error, message string) error {
+	return tscerr.Wrap(apiErrorKind(err), err, message)
+}
+
+func wrapf(err error, format string, args ...interface{}) error {
+	return tscerr.Wrapf(apiErrorKind(err), err, format, args...)
+}
+
+// retryable tells whether failed EC2 API call should be retried
+func retryable(err error) bool {
+	if retryableCodes[APIErrorCode(err)] {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500 {
+		return true
+	}
+	return retry.Transient(err)
+}
@@ -2,8 +2,9 @@ package ec2cli
 
 import (
 	"context"
-	"fmt"
+	"encoding/base64"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,36 +16,62 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/retry"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
 )
 
-var ec2Client *ec2.Client
-var once = &sync.Once{}
-
-func initClient() {
-	once.Do(func() {
-		crd := creds.Get()
-		ec2Client = ec2.New(ec2.Options{
-			Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
-				return aws.Credentials{
-					AccessKeyID:     crd.AwsAccessKeyID,
-					SecretAccessKey: crd.AwsSecretAccessKey,
-				}, nil
-			}),
+// every resource created by tscalectl carries one of these Description tags
+const (
+	KeyPairDescription       = "tailscalectl managed key pair"
+	SecurityGroupDescription = "tailscalectl managed security group"
+	InstanceDescription      = "tailscalectl managed ec2 instance"
+)
+
+// CreatedAtTag is set on security groups, AWS does not track their creation time
+const CreatedAtTag = "CreatedAt"
+
+// clients are kept per context, every context has its own AWS credentials
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*ec2.Client{}
+)
+
+func client(ctx context.Context) *ec2.Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	c, ok := clients[tscos.Context]
+	if !ok {
+		c = ec2.New(ec2.Options{
+			Credentials: creds.AWS(ctx),
+			// calls are retried by call, with the policy shared by all commands
+			Retryer: aws.NopRetryer{},
 		})
-	})
+		clients[tscos.Context] = c
+	}
+	return c
 }
 
-func Regions() []string {
-	initClient()
+// call calls EC2 API, failed attempts are retried with shared retry policy and every attempt has its own deadline
+func call(ctx context.Context, fn func(ctx context.Context) error) error {
+	return retry.Do(ctx, retry.EC2(), retryable, fn)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
+func Regions(ctx context.Context) []string {
+	ec2Client := client(ctx)
 
-	res, err := ec2Client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{}, func(options *ec2.Options) {
-		options.Region = "eu-central-1"
+	var res *ec2.DescribeRegionsOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		res, err = ec2Client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{}, func(options *ec2.Options) {
+			options.Region = "eu-central-1"
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, regions"))
+		panic(wrap(err, "ec2cli, regions"))
 	}
 
 	regionNames := make([]string, 0, len(res.Regions))
@@ -57,28 +84,29 @@ func Regions() []string {
 	return regionNames
 }
 
-func InstanceTypesPerRegion(region string) []string {
-	initClient()
+func InstanceTypesPerRegion(ctx context.Context, region string) []string {
+	ec2Client := client(ctx)
 
 	instanceTypes := make([]string, 0, 20)
 	var nextToken *string
 	for {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-
-		res, err := ec2Client.DescribeInstanceTypeOfferings(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
-			Filters: []types.Filter{
-				{
-					Name:   aws.String("location"),
-					Values: []string{region},
+		var res *ec2.DescribeInstanceTypeOfferingsOutput
+		err := call(ctx, func(ctx context.Context) (err error) {
+			res, err = ec2Client.DescribeInstanceTypeOfferings(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+				Filters: []types.Filter{
+					{
+						Name:   aws.String("location"),
+						Values: []string{region},
+					},
 				},
-			},
-			NextToken: nextToken,
-		}, func(options *ec2.Options) {
-			options.Region = region
+				NextToken: nextToken,
+			}, func(options *ec2.Options) {
+				options.Region = region
+			})
+			return err
 		})
-		cancel()
 		if err != nil {
-			panic(errors.Wrap(err, "ec2cli, instance types per region"))
+			panic(wrap(err, "ec2cli, instance types per region"))
 		}
 
 		nextToken = res.NextToken
@@ -97,28 +125,29 @@ func InstanceTypesPerRegion(region string) []string {
 	return instanceTypes
 }
 
-func AMIsPerRegion(region string) []string {
-	initClient()
+func AMIsPerRegion(ctx context.Context, region string) []string {
+	ec2Client := client(ctx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	res, err := ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{
-		Filters: []types.Filter{
-			{
-				Name:   aws.String("name"),
-				Values: []string{"ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20220609"},
-			},
-			{
-				Name:   aws.String("owner-id"),
-				Values: []string{"099720109477"},
+	var res *ec2.DescribeImagesOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		res, err = ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("name"),
+					Values: []string{"ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20220609"},
+				},
+				{
+					Name:   aws.String("owner-id"),
+					Values: []string{"099720109477"},
+				},
 			},
-		},
-	}, func(options *ec2.Options) {
-		options.Region = region
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, AMIs per region"))
+		panic(wrap(err, "ec2cli, AMIs per region"))
 	}
 
 	images := make([]string, 0, len(res.Images))
@@ -131,314 +160,596 @@ func AMIsPerRegion(region string) []string {
 	return images
 }
 
-func ImportKeyPair(region string, keyName string, pubKey ssh.PublicKey) {
-	initClient()
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	_, err := ec2Client.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
-		KeyName:           aws.String(keyName),
-		PublicKeyMaterial: ssh.MarshalAuthorizedKey(pubKey),
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeKeyPair,
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(keyName),
-					},
-					{
-						Key:   aws.String("Description"),
-						Value: aws.String("tailscalectl managed key pair"),
+func ImportKeyPair(ctx context.Context, region string, keyName string, pubKey ssh.PublicKey) string {
+	ec2Client := client(ctx)
+
+	attempts := 0
+	var keyPairOut *ec2.ImportKeyPairOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		attempts++
+		keyPairOut, err = ec2Client.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
+			KeyName:           aws.String(keyName),
+			PublicKeyMaterial: ssh.MarshalAuthorizedKey(pubKey),
+			TagSpecifications: []types.TagSpecification{
+				{
+					ResourceType: types.ResourceTypeKeyPair,
+					Tags: []types.Tag{
+						{
+							Key:   aws.String("Name"),
+							Value: aws.String(keyName),
+						},
+						{
+							Key:   aws.String("Description"),
+							Value: aws.String(KeyPairDescription),
+						},
 					},
 				},
 			},
-		},
-	}, func(options *ec2.Options) {
-		options.Region = region
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, import key pair"))
+		// retried attempt finds key pair created by the attempt whose response was lost
+		if attempts > 1 && APIErrorCode(err) == "InvalidKeyPair.Duplicate" {
+			if keyPairID, ok := FindKeyPair(ctx, region, keyName); ok {
+				return keyPairID
+			}
+		}
+		panic(wrap(err, "ec2cli, import key pair"))
 	}
-}
 
-func CreateSecurityGroup(region string, securityGroupName string) string {
-	initClient()
+	return *keyPairOut.KeyPairId
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
+func CreateSecurityGroup(ctx context.Context, region string, securityGroupName string, sshIngress bool) string {
+	ec2Client := client(ctx)
 
-	secGrOut, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
-		Description: aws.String("tailscalectl managed security group"),
-		GroupName:   aws.String(securityGroupName),
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeSecurityGroup,
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(securityGroupName),
-					},
-					{
-						Key:   aws.String("Description"),
-						Value: aws.String("tailscalectl managed security group"),
+	var secGrOut *ec2.CreateSecurityGroupOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		secGrOut, err = ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+			Description: aws.String(SecurityGroupDescription),
+			GroupName:   aws.String(securityGroupName),
+			TagSpecifications: []types.TagSpecification{
+				{
+					ResourceType: types.ResourceTypeSecurityGroup,
+					Tags: []types.Tag{
+						{
+							Key:   aws.String("Name"),
+							Value: aws.String(securityGroupName),
+						},
+						{
+							Key:   aws.String("Description"),
+							Value: aws.String(SecurityGroupDescription),
+						},
+						{
+							Key:   aws.String(CreatedAtTag),
+							Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+						},
 					},
 				},
 			},
-		},
-	}, func(options *ec2.Options) {
-		options.Region = region
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, create security group"))
+		// group with node name was created by the attempt whose response was lost, or by an earlier run which stopped
+		// before the group was stored in CLI state (e.g. up --resume after failed ingress), so it is taken over
+		if APIErrorCode(err) == "InvalidGroup.Duplicate" {
+			if id, ok := FindSecurityGroup(ctx, region, securityGroupName); ok {
+				secGrOut = &ec2.CreateSecurityGroupOutput{GroupId: aws.String(id)}
+			}
+		}
+		if secGrOut == nil {
+			panic(wrap(err, "ec2cli, create security group"))
+		}
 	}
+	securityGroupID := *secGrOut.GroupId
 
-	_, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: secGrOut.GroupId,
-		IpPermissions: []types.IpPermission{
-			{
-				FromPort:   aws.Int32(22),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp:      aws.String("0.0.0.0/0"),
-						Description: aws.String("Allow only SSH connections"),
+	// nodes bootstrapped with cloud-init don't need SSH access from the internet
+	if !sshIngress {
+		return securityGroupID
+	}
+
+	err = call(ctx, func(ctx context.Context) (err error) {
+		_, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId: aws.String(securityGroupID),
+			IpPermissions: []types.IpPermission{
+				{
+					FromPort:   aws.Int32(22),
+					IpProtocol: aws.String("tcp"),
+					IpRanges: []types.IpRange{
+						{
+							CidrIp:      aws.String("0.0.0.0/0"),
+							Description: aws.String("Allow only SSH connections"),
+						},
 					},
+					ToPort: aws.Int32(22),
 				},
-				ToPort: aws.Int32(22),
 			},
-		},
-	}, func(options *ec2.Options) {
-		options.Region = region
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
-	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, authorize security group ingress"))
+	// taken over group, or group of the attempt whose response was lost, may already allow SSH
+	if err != nil && APIErrorCode(err) != "InvalidPermission.Duplicate" {
+		// caller records the group only after it is returned, so group without ingress would be left behind
+		deleteUnusedSecurityGroup(region, securityGroupID)
+		panic(wrap(err, "ec2cli, authorize security group ingress"))
 	}
 
-	return *secGrOut.GroupId
+	return securityGroupID
+}
+
+// deleteUnusedSecurityGroup deletes group which was not set up, failure to delete it is only reported
+// (ctx may already be canceled by interrupt, so cleanup does not use it)
+func deleteUnusedSecurityGroup(region string, securityGroupID string) {
+	defer func() {
+		if r := recover(); r != nil {
+			tsclog.Warnf("Security group %s was not deleted, it is deleted by tscalectl gc: %v", securityGroupID, r)
+		}
+	}()
+
+	DeleteSecurityGroup(context.Background(), region, securityGroupID)
 }
 
-func RunInstance(region string, instanceType string, ami string, vpnNodeName string, securityGroupID string) string {
-	initClient()
+func RunInstance(ctx context.Context, region string, instanceType string, ami string, vpnNodeName string, securityGroupID string, userData string) types.Instance {
+	ec2Client := client(ctx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
+	var encodedUserData *string
+	if userData != "" {
+		encodedUserData = aws.String(base64.StdEncoding.EncodeToString([]byte(userData)))
+	}
 
-	runInstOut, err := ec2Client.RunInstances(ctx, &ec2.RunInstancesInput{
-		MaxCount:         aws.Int32(1),
-		MinCount:         aws.Int32(1),
-		ImageId:          aws.String(ami),
-		InstanceType:     types.InstanceType(instanceType),
-		KeyName:          aws.String(vpnNodeName),
-		SecurityGroupIds: []string{securityGroupID},
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeInstance,
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(vpnNodeName),
-					},
-					{
-						Key:   aws.String("Description"),
-						Value: aws.String("tailscalectl managed ec2 instance"),
+	// retried attempts carry the same client token, so AWS launches only one instance if response was lost
+	clientToken := vpnNodeName + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	var runInstOut *ec2.RunInstancesOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		runInstOut, err = ec2Client.RunInstances(ctx, &ec2.RunInstancesInput{
+			ClientToken:      aws.String(clientToken),
+			UserData:         encodedUserData,
+			MaxCount:         aws.Int32(1),
+			MinCount:         aws.Int32(1),
+			ImageId:          aws.String(ami),
+			InstanceType:     types.InstanceType(instanceType),
+			KeyName:          aws.String(vpnNodeName),
+			SecurityGroupIds: []string{securityGroupID},
+			TagSpecifications: []types.TagSpecification{
+				{
+					ResourceType: types.ResourceTypeInstance,
+					Tags: []types.Tag{
+						{
+							Key:   aws.String("Name"),
+							Value: aws.String(vpnNodeName),
+						},
+						{
+							Key:   aws.String("Description"),
+							Value: aws.String(InstanceDescription),
+						},
 					},
 				},
 			},
-		},
-	}, func(options *ec2.Options) {
-		options.Region = region
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, run instance"))
+		panic(wrap(err, "ec2cli, run instance"))
 	}
 
-	return *runInstOut.Instances[0].InstanceId
+	return runInstOut.Instances[0]
 }
 
-func TerminateInstance(region string, vpnNodeName string) {
-	initClient()
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	descInstOut, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
-			{
-				Name:   aws.String("tag:Name"),
-				Values: []string{vpnNodeName},
-			},
-		},
-	}, func(options *ec2.Options) {
-		options.Region = region
-	})
-	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, terminate instance, describe"))
-	}
-
-	// somebody already terminated instance, e.g. manually through AWS Console
-	// or with tscalectl down command (which did not finish properly because of e.g. internet connection errors)
-	//
-	// or instance was never created. E.g. in tscalectl up command, wrong ami was passed
-	if len(descInstOut.Reservations) == 0 {
-		return
-	}
+func TerminateInstance(ctx context.Context, region string, ec2InstanceID string) {
+	ec2Client := client(ctx)
 
-	termInstOut, err := ec2Client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
-		InstanceIds: []string{*descInstOut.Reservations[0].Instances[0].InstanceId},
-		DryRun:      nil,
-	}, func(options *ec2.Options) {
-		options.Region = region
+	var termInstOut *ec2.TerminateInstancesOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		termInstOut, err = ec2Client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []string{ec2InstanceID},
+			DryRun:      nil,
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, terminate instance, terminate"))
+		// somebody terminated instance long time ago (e.g. manually through AWS Console), and AWS already removed it
+		if APIErrorCode(err) == "InvalidInstanceID.NotFound" {
+			return
+		}
+		panic(wrap(err, "ec2cli, terminate instance"))
 	}
 
-	// should not happen because describe was successful
-	// however, if someone terminates instance manually, and then AWS removes it completely just between describe and
-	// terminate calls, this error should happen
 	if len(termInstOut.TerminatingInstances) != 1 {
 		panic(errors.Errorf("ec2cli, terminate instance, terminating != 1 instance; num=%d", len(termInstOut.TerminatingInstances)))
 	}
 }
 
-func DeleteSecurityGroup(region string, vpnNodeName string) {
-	initClient()
+func DeleteSecurityGroup(ctx context.Context, region string, securityGroupID string) {
+	ec2Client := client(ctx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	_, err := ec2Client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
-		GroupName: aws.String(vpnNodeName),
-	}, func(options *ec2.Options) {
-		options.Region = region
+	err := call(ctx, func(ctx context.Context) (err error) {
+		_, err = ec2Client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(securityGroupID),
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "does not exist in default VPC") {
 			return
 		}
-		panic(errors.Wrap(err, "ec2cli, delete security group"))
+		panic(wrap(err, "ec2cli, delete security group"))
 	}
 }
 
-func DeleteKeyPair(region string, vpnNodeName string) {
-	initClient()
+func DeleteKeyPair(ctx context.Context, region string, keyPairID string) {
+	ec2Client := client(ctx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	_, err := ec2Client.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{
-		KeyName: aws.String(vpnNodeName),
-	}, func(options *ec2.Options) {
-		options.Region = region
+	err := call(ctx, func(ctx context.Context) (err error) {
+		_, err = ec2Client.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{
+			KeyPairId: aws.String(keyPairID),
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, delete key pair"))
+		if strings.Contains(err.Error(), "NotFound") {
+			return
+		}
+		panic(wrap(err, "ec2cli, delete key pair"))
 	}
 }
 
-func WaitForInstanceToInitialize(region string, ec2InstanceID string) {
-	initClient()
+func WaitForInstanceToInitialize(ctx context.Context, region string, ec2InstanceID string) {
+	ec2Client := client(ctx)
 
 	startTime := time.Now()
 	seenInstanceStatus := false
 	for {
-		time.Sleep(time.Second * 5)
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-
-		statusOut, err := ec2Client.DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
-			InstanceIds: []string{ec2InstanceID},
-		}, func(options *ec2.Options) {
-			options.Region = region
+		interrupt.Sleep(ctx, time.Second*5)
+
+		var statusOut *ec2.DescribeInstanceStatusOutput
+		err := call(ctx, func(ctx context.Context) (err error) {
+			statusOut, err = ec2Client.DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
+				InstanceIds: []string{ec2InstanceID},
+			}, func(options *ec2.Options) {
+				options.Region = region
+			})
+			return err
 		})
-		cancel()
 		if err != nil {
-			panic(errors.Wrap(err, "ec2cli, wait for instance to initialize, describe instance status"))
+			panic(wrap(err, "ec2cli, wait for instance to initialize, describe instance status"))
 		}
 
 		if len(statusOut.InstanceStatuses) < 1 && seenInstanceStatus {
-			panic(errors.New("ec2cli, wait for instance to initialize, lost instance"))
+			panic(tscerr.New(tscerr.NotFound, "ec2cli, wait for instance to initialize, lost instance"))
 		}
 
 		if len(statusOut.InstanceStatuses) < 1 {
-			fmt.Println("No instance info yet, continuing to wait...", time.Since(startTime))
+			tsclog.Infof("No instance info yet, continuing to wait... %s", time.Since(startTime))
 			continue
 		}
+		seenInstanceStatus = true
 
-		if statusOut.InstanceStatuses[0].InstanceStatus.Status == "initializing" && statusOut.InstanceStatuses[0].InstanceStatus.Details[0].Status == "initializing" {
-			seenInstanceStatus = true
-			fmt.Println("Instance currently initializing, continuing to wait...", time.Since(startTime))
-			continue
-		} else if statusOut.InstanceStatuses[0].InstanceStatus.Status == "ok" && statusOut.InstanceStatuses[0].InstanceStatus.Details[0].Status == "passed" {
-			fmt.Println("Instance ready", time.Since(startTime))
-			break
-		} else {
-			panic(errors.Errorf("ec2cli, wait for instance to initialize,  instance not initialized properly; status=%s, detailsStatus=%s", statusOut.InstanceStatuses[0].InstanceStatus.Status, statusOut.InstanceStatuses[0].InstanceStatus.Details[0].Status))
+		status, reachability := instanceStatus(statusOut.InstanceStatuses[0])
+		switch {
+		case status == types.SummaryStatusOk && (reachability == types.StatusTypePassed || reachability == ""):
+			tsclog.Infof("Instance ready %s", time.Since(startTime))
+			return
+		case status == types.SummaryStatusImpaired || reachability == types.StatusTypeFailed:
+			panic(errors.Errorf("ec2cli, wait for instance to initialize, instance failed status checks; status=%s, reachability=%s", status, reachability))
+		default:
+			// initializing, insufficient-data or not-applicable, checks did not finish yet
+			tsclog.Infof("Instance currently %s, continuing to wait... %s", status, time.Since(startTime))
+		}
+	}
+}
+
+// instanceStatus returns summary of instance status checks and status of its reachability check (empty if AWS
+// did not report it yet)
+func instanceStatus(st types.InstanceStatus) (types.SummaryStatus, types.StatusType) {
+	if st.InstanceStatus == nil {
+		return types.SummaryStatusInitializing, ""
+	}
+
+	for _, d := range st.InstanceStatus.Details {
+		if d.Name == types.StatusNameReachability {
+			return st.InstanceStatus.Status, d.Status
 		}
 	}
+
+	return st.InstanceStatus.Status, ""
 }
 
-func WaitForInstanceToTerminate(region string, vpnNodeName string) {
-	initClient()
+func WaitForInstanceToTerminate(ctx context.Context, region string, ec2InstanceID string) {
+	ec2Client := client(ctx)
 
 	startTime := time.Now()
 	for {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-
-		descInstOut, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-			Filters: []types.Filter{
-				{
-					Name:   aws.String("tag:Name"),
-					Values: []string{vpnNodeName},
-				},
-			},
-		}, func(options *ec2.Options) {
-			options.Region = region
+		var descInstOut *ec2.DescribeInstancesOutput
+		err := call(ctx, func(ctx context.Context) (err error) {
+			descInstOut, err = ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+				InstanceIds: []string{ec2InstanceID},
+			}, func(options *ec2.Options) {
+				options.Region = region
+			})
+			return err
 		})
-		cancel()
 		if err != nil {
-			panic(errors.Wrap(err, "ec2cli, wait for instances to terminate, describe"))
+			// instance was terminated long time ago and AWS already removed it
+			if APIErrorCode(err) == "InvalidInstanceID.NotFound" {
+				return
+			}
+			panic(wrap(err, "ec2cli, wait for instances to terminate, describe"))
 		}
 
-		// if instance was never before created or was manually deleted
 		if len(descInstOut.Reservations) == 0 {
 			return
 		}
 
 		if len(descInstOut.Reservations[0].Instances) != 1 {
-			panic(errors.Errorf("ec2cli, wait for instance to terminate, wrong num of instances with requested vpn node name; num=%d", len(descInstOut.Reservations[0].Instances)))
+			panic(errors.Errorf("ec2cli, wait for instance to terminate, wrong num of instances with requested instance ID; num=%d", len(descInstOut.Reservations[0].Instances)))
 		}
 
 		if descInstOut.Reservations[0].Instances[0].State.Name != types.InstanceStateNameTerminated {
-			fmt.Printf("Instance state: %s, waiting to terminate... %s\n", descInstOut.Reservations[0].Instances[0].State.Name, time.Since(startTime))
-			time.Sleep(time.Second * 5)
+			tsclog.Infof("Instance state: %s, waiting to terminate... %s", descInstOut.Reservations[0].Instances[0].State.Name, time.Since(startTime))
+			interrupt.Sleep(ctx, time.Second*5)
 		} else {
-			fmt.Printf("Instance state: %s, %s\n", descInstOut.Reservations[0].Instances[0].State.Name, time.Since(startTime))
+			tsclog.Infof("Instance state: %s, %s", descInstOut.Reservations[0].Instances[0].State.Name, time.Since(startTime))
 			return
 		}
 	}
 }
 
-func DescribeInstance(region string, vpnNodeName string) string {
-	initClient()
+func DescribeInstance(ctx context.Context, region string, ec2InstanceID string) types.Instance {
+	ec2Client := client(ctx)
+
+	var descOut *ec2.DescribeInstancesOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		descOut, err = ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: []string{ec2InstanceID},
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
+	})
+	if err != nil {
+		panic(wrap(err, "ec2cli, describe instance"))
+	}
+
+	if len(descOut.Reservations) == 0 || len(descOut.Reservations[0].Instances) == 0 {
+		panic(tscerr.Errorf(tscerr.NotFound, "ec2cli, describe instance, instance does not exist; instance-id=%s", ec2InstanceID))
+	}
+
+	return descOut.Reservations[0].Instances[0]
+}
+
+// FindInstance finds instance which is not terminated by its Name tag. It is used for nodes created by older
+// tscalectl versions, which did not store instance ID. (Names are reused, so terminated instances are skipped.)
+func FindInstance(ctx context.Context, region string, vpnNodeName string) (types.Instance, bool) {
+	ec2Client := client(ctx)
+
+	var descOut *ec2.DescribeInstancesOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		descOut, err = ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("tag:Name"),
+					Values: []string{vpnNodeName},
+				},
+				{
+					Name:   aws.String("instance-state-name"),
+					Values: []string{"pending", "running", "shutting-down", "stopping", "stopped"},
+				},
+			},
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
+	})
+	if err != nil {
+		panic(wrap(err, "ec2cli, find instance"))
+	}
+
+	if len(descOut.Reservations) == 0 || len(descOut.Reservations[0].Instances) == 0 {
+		return types.Instance{}, false
+	}
+
+	return descOut.Reservations[0].Instances[0], true
+}
+
+// FindSecurityGroup finds security group ID by group name, see FindInstance
+func FindSecurityGroup(ctx context.Context, region string, securityGroupName string) (string, bool) {
+	ec2Client := client(ctx)
+
+	var descOut *ec2.DescribeSecurityGroupsOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		descOut, err = ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("group-name"),
+					Values: []string{securityGroupName},
+				},
+			},
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
+	})
+	if err != nil {
+		panic(wrap(err, "ec2cli, find security group"))
+	}
+
+	if len(descOut.SecurityGroups) == 0 {
+		return "", false
+	}
+
+	return *descOut.SecurityGroups[0].GroupId, true
+}
+
+// FindKeyPair finds key pair ID by key name, see FindInstance
+func FindKeyPair(ctx context.Context, region string, keyName string) (string, bool) {
+	ec2Client := client(ctx)
+
+	var descOut *ec2.DescribeKeyPairsOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		descOut, err = ec2Client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("key-name"),
+					Values: []string{keyName},
+				},
+			},
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
+	})
+	if err != nil {
+		panic(wrap(err, "ec2cli, find key pair"))
+	}
+
+	if len(descOut.KeyPairs) == 0 {
+		return "", false
+	}
+
+	return *descOut.KeyPairs[0].KeyPairId, true
+}
+
+// ManagedInstances returns every instance tagged as tscalectl managed, terminated instances are returned
+// too while AWS still lists them (about an hour after termination)
+func ManagedInstances(ctx context.Context, region string) []types.Instance {
+	ec2Client := client(ctx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
+	paginator := ec2.NewDescribeInstancesPaginator(ec2Client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:Description"),
+				Values: []string{InstanceDescription},
+			},
+		},
+	})
+
+	var instances []types.Instance
+	for paginator.HasMorePages() {
+		// failed page is requested again, paginator moves on only after successful page
+		var descOut *ec2.DescribeInstancesOutput
+		err := call(ctx, func(ctx context.Context) (err error) {
+			descOut, err = paginator.NextPage(ctx, func(options *ec2.Options) {
+				options.Region = region
+			})
+			return err
+		})
+		if err != nil {
+			panic(wrapf(err, "ec2cli, managed instances; region=%s", region))
+		}
 
-	descOut, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		for _, r := range descOut.Reservations {
+			instances = append(instances, r.Instances...)
+		}
+	}
+
+	return instances
+}
+
+func ManagedSecurityGroups(ctx context.Context, region string) []types.SecurityGroup {
+	ec2Client := client(ctx)
+
+	paginator := ec2.NewDescribeSecurityGroupsPaginator(ec2Client, &ec2.DescribeSecurityGroupsInput{
 		Filters: []types.Filter{
 			{
-				Name:   aws.String("tag:Name"),
-				Values: []string{vpnNodeName},
+				Name:   aws.String("tag:Description"),
+				Values: []string{SecurityGroupDescription},
 			},
 		},
-	}, func(options *ec2.Options) {
-		options.Region = region
+	})
+
+	var securityGroups []types.SecurityGroup
+	for paginator.HasMorePages() {
+		var descOut *ec2.DescribeSecurityGroupsOutput
+		err := call(ctx, func(ctx context.Context) (err error) {
+			descOut, err = paginator.NextPage(ctx, func(options *ec2.Options) {
+				options.Region = region
+			})
+			return err
+		})
+		if err != nil {
+			panic(wrapf(err, "ec2cli, managed security groups; region=%s", region))
+		}
+
+		securityGroups = append(securityGroups, descOut.SecurityGroups...)
+	}
+
+	return securityGroups
+}
+
+func ManagedKeyPairs(ctx context.Context, region string) []types.KeyPairInfo {
+	ec2Client := client(ctx)
+
+	var descOut *ec2.DescribeKeyPairsOutput
+	err := call(ctx, func(ctx context.Context) (err error) {
+		descOut, err = ec2Client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("tag:Description"),
+					Values: []string{KeyPairDescription},
+				},
+			},
+		}, func(options *ec2.Options) {
+			options.Region = region
+		})
+		return err
 	})
 	if err != nil {
-		panic(errors.Wrap(err, "ec2cli, describe instance"))
+		panic(wrapf(err, "ec2cli, managed key pairs; region=%s", region))
 	}
 
-	return *descOut.Reservations[0].Instances[0].PublicIpAddress
+	return descOut.KeyPairs
+}
+
+func ConsoleOutput(ctx context.Context, region string, ec2InstanceID string) string {
+	ec2Client := client(ctx)
+
+	// latest output is supported only on nitro instances, others get output buffered by AWS (it lags for few minutes)
+	latest := true
+	for {
+		var out *ec2.GetConsoleOutputOutput
+		err := call(ctx, func(ctx context.Context) (err error) {
+			out, err = ec2Client.GetConsoleOutput(ctx, &ec2.GetConsoleOutputInput{
+				InstanceId: aws.String(ec2InstanceID),
+				Latest:     aws.Bool(latest),
+			}, func(options *ec2.Options) {
+				options.Region = region
+			})
+			return err
+		})
+		if err != nil {
+			if latest && strings.Contains(err.Error(), "UnsupportedOperation") {
+				latest = false
+				continue
+			}
+			panic(wrap(err, "ec2cli, console output"))
+		}
+
+		if out.Output == nil {
+			return ""
+		}
+
+		b, err := base64.StdEncoding.DecodeString(*out.Output)
+		if err != nil {
+			panic(wrap(err, "ec2cli, console output, base64 decode"))
+		}
+
+		return string(b)
+	}
 }
@@ -0,0 +1,125 @@
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// EC2 refuses user data bigger than 16KB (before base64 encoding)
+const maxUserDataSize = 16 * 1024
+
+// bootstrap script prints one of the markers to the console, CLI reads it from instance console output
+const (
+	MarkerOK     = "tscalectl-bootstrap: ok"
+	MarkerFailed = "tscalectl-bootstrap: failed"
+)
+
+// authKeyFile keeps tailscale auth key out of bootstrap script and process arguments, bootstrap deletes it.
+// Key stays in user data, which instance metadata and DescribeInstanceAttribute return for the instance lifetime.
+const authKeyFile = "/var/lib/tscalectl/auth-key"
+
+var cloudConfig = template.Must(template.New("cloud-config").Parse(`#cloud-config
+write_files:
+  - path: /etc/sysctl.d/99-tscalectl.conf
+    content: |
+      net.ipv4.ip_forward = 1
+      net.ipv6.conf.all.forwarding = 1
+  - path: ` + authKeyFile + `
+    owner: root:root
+    permissions: '0600'
+    content: '{{.AuthKey}}'
+  - path: /var/lib/tscalectl/bootstrap.sh
+    permissions: '0700'
+    content: |
+      #!/bin/sh
+      if sysctl -p /etc/sysctl.d/99-tscalectl.conf \
+        && curl -fsSL -o /tmp/tailscale-install.sh https://tailscale.com/install.sh \
+        && sh /tmp/tailscale-install.sh \
+        && tailscale up --auth-key file:` + authKeyFile + ` --hostname '{{.Hostname}}'{{if .ExitNode}} --advertise-exit-node{{end}}
+      then
+        echo "` + MarkerOK + `" | tee /dev/console
+      else
+        echo "` + MarkerFailed + `, see /var/log/cloud-init-output.log" | tee /dev/console
+      fi
+      rm -f ` + authKeyFile + `
+runcmd:
+  - [/var/lib/tscalectl/bootstrap.sh]
+`))
+
+// UserData renders tailscale installation, IP forwarding settings and tailscale up into the multipart cloud-init
+// user data. User provided cloud-config fragments are appended as separate parts, cloud-init merges them
+// by appending lists (e.g. runcmd, write_files) to the ones defined by tscalectl.
+func UserData(tailscaleAuthKey string, hostname string, exitNode bool, fragments [][]byte) string {
+	var cfg bytes.Buffer
+	err := cloudConfig.Execute(&cfg, struct {
+		AuthKey  string
+		Hostname string
+		ExitNode bool
+	}{
+		AuthKey:  tailscaleAuthKey,
+		Hostname: hostname,
+		ExitNode: exitNode,
+	})
+	if err != nil {
+		panic(errors.Wrap(err, "cloud init, user data, execute template"))
+	}
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n", w.Boundary())
+
+	writePart(w, "tscalectl.cfg", cfg.Bytes())
+	for i, fragment := range fragments {
+		if !strings.HasPrefix(string(fragment), "#cloud-config") {
+			panic(errors.Errorf("cloud init, user data, fragment is not cloud-config (it has to start with #cloud-config); fragment=%d", i))
+		}
+		writePart(w, fmt.Sprintf("user-%d.cfg", i), fragment)
+	}
+
+	if err = w.Close(); err != nil {
+		panic(errors.Wrap(err, "cloud init, user data, close multipart writer"))
+	}
+
+	if b.Len() > maxUserDataSize {
+		panic(errors.Errorf("cloud init, user data, too big; size=%d, max-size=%d", b.Len(), maxUserDataSize))
+	}
+
+	return b.String()
+}
+
+func writePart(w *multipart.Writer, filename string, content []byte) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "text/cloud-config; charset=\"us-ascii\"")
+	header.Set("MIME-Version", "1.0")
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	header.Set("Merge-Type", "list(append)+dict(no_replace,recurse_list)+str()")
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		panic(errors.Wrap(err, "cloud init, user data, create part"))
+	}
+
+	if _, err = part.Write(content); err != nil {
+		panic(errors.Wrap(err, "cloud init, user data, write part"))
+	}
+}
+
+// Result looks for bootstrap marker in instance console output
+func Result(consoleOutput string) (finished bool, err error) {
+	for _, line := range strings.Split(consoleOutput, "\n") {
+		if strings.Contains(line, MarkerFailed) {
+			return true, errors.Errorf("cloud init, bootstrap failed; console=%s", strings.TrimSpace(line))
+		}
+		if strings.Contains(line, MarkerOK) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
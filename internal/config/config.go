@@ -0,0 +1,173 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// Config is read from ~/.tscalectl/config.json, every value is optional and flags given on command line override it
+type Config struct {
+	Retry Retry `json:"retry"`
+	// AWS is used by default context, named contexts have their own
+	AWS AWS `json:"aws"`
+
+	// CurrentContext is used when --context is not given, empty is default context
+	CurrentContext string             `json:"current_context,omitempty"`
+	Contexts       map[string]Context `json:"contexts,omitempty"`
+}
+
+// Context bundles an AWS account and a tailnet, its credentials, state, keys and logs are kept in its own directory
+type Context struct {
+	AWS AWS `json:"aws"`
+	// TailscaleAuthKeyEnv is name of environment variable with tailscale auth key, empty reads credentials file
+	TailscaleAuthKeyEnv string `json:"tailscale_auth_key_env,omitempty"`
+	// Region is default region of up
+	Region string `json:"region,omitempty"`
+}
+
+// Flags returns values which are set in config and are shared by all contexts, keyed by name of the flag they are
+// default for
+func (c Config) Flags() map[string]string {
+	return c.Retry.Flags()
+}
+
+// Context returns context with the name, default context is always found
+func (c Config) Context(name string) (Context, bool) {
+	if name == tscos.DefaultContext {
+		return Context{AWS: c.AWS}, true
+	}
+	ctx, ok := c.Contexts[name]
+	return ctx, ok
+}
+
+// MustContext returns context with the name, it panics if there is no such context
+func (c Config) MustContext(name string) Context {
+	ctx, ok := c.Context(name)
+	if !ok {
+		panic(tscerr.Errorf(tscerr.NotFound, "config, context does not exist, see context list; context=%s", name))
+	}
+	return ctx
+}
+
+// ContextNames returns default context and names of contexts in config, sorted
+func (c Config) ContextNames() []string {
+	names := []string{tscos.DefaultContext}
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Retry overrides defaults of retry policy (see retry package), durations are written as "500ms", "20s" or "3m"
+type Retry struct {
+	Attempts          int    `json:"attempts,omitempty"`
+	BaseDelay         string `json:"base_delay,omitempty"`
+	MaxDelay          string `json:"max_delay,omitempty"`
+	EC2Timeout        string `json:"ec2_timeout,omitempty"`
+	SSHDialTimeout    string `json:"ssh_dial_timeout,omitempty"`
+	SSHReadyTimeout   string `json:"ssh_ready_timeout,omitempty"`
+	SSHCommandTimeout string `json:"ssh_command_timeout,omitempty"`
+}
+
+// AWS chooses where AWS credentials come from (see creds package)
+type AWS struct {
+	Creds         string `json:"creds,omitempty"`
+	Profile       string `json:"profile,omitempty"`
+	AssumeRoleARN string `json:"assume_role_arn,omitempty"`
+}
+
+// Load reads config file, missing file is an empty config
+func Load() Config {
+	var cfg Config
+
+	b, err := os.ReadFile(tscos.ConfigFile())
+	if os.IsNotExist(err) {
+		return cfg
+	} else if err != nil {
+		panic(err)
+	}
+
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		panic(tscerr.Wrapf(tscerr.BadInput, err, "config, json unmarshal; file=%s", tscos.ConfigFile()))
+	}
+
+	return cfg
+}
+
+// SetFlags sets flags which are not given on command line to values from config file. Flags stay unchanged
+// (see pflag.FlagSet.Changed), so Changed still tells whether user gave the flag.
+func SetFlags(flags *pflag.FlagSet, values map[string]string) {
+	for name, value := range values {
+		f := flags.Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			panic(tscerr.Wrapf(tscerr.BadInput, err, "config, invalid value; file=%s, flag=%s", tscos.ConfigFile(), name))
+		}
+	}
+}
+
+// Update reads config file, calls fn with it and writes changed config back, config file is locked meanwhile
+func Update(fn func(cfg *Config)) {
+	unlock := fileutil.Lock(tscos.ConfigLockFile())
+	defer unlock()
+
+	cfg := Load()
+	fn(&cfg)
+
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		panic(errors.Wrap(err, "config, json marshal"))
+	}
+	fileutil.WriteFile(tscos.ConfigFile(), b)
+}
+
+// Flags returns values which are set in config, keyed by name of the flag they are default for
+func (r Retry) Flags() map[string]string {
+	flags := map[string]string{
+		"retry-base-delay":    r.BaseDelay,
+		"retry-max-delay":     r.MaxDelay,
+		"ec2-timeout":         r.EC2Timeout,
+		"ssh-dial-timeout":    r.SSHDialTimeout,
+		"ssh-ready-timeout":   r.SSHReadyTimeout,
+		"ssh-command-timeout": r.SSHCommandTimeout,
+	}
+	if r.Attempts != 0 {
+		flags["retry-attempts"] = strconv.Itoa(r.Attempts)
+	}
+
+	for name, value := range flags {
+		if value == "" {
+			delete(flags, name)
+		}
+	}
+
+	return flags
+}
+
+// Flags returns values of context, keyed by name of the flag they are default for. Unlike other flags, every
+// context flag is returned, unset ones with their default, so switching contexts doesn't keep values of another one.
+func (c Context) Flags() map[string]string {
+	awsCreds := c.AWS.Creds
+	if awsCreds == "" {
+		awsCreds = "file"
+	}
+
+	return map[string]string{
+		"aws-creds":              awsCreds,
+		"aws-profile":            c.AWS.Profile,
+		"assume-role-arn":        c.AWS.AssumeRoleARN,
+		"tailscale-auth-key-env": c.TailscaleAuthKeyEnv,
+	}
+}
@@ -0,0 +1,34 @@
+package cost
+
+import (
+	"time"
+)
+
+// hourlyUSD are on-demand Linux prices in us-east-1, other regions differ by a few percent, which is good enough
+// for an estimate
+var hourlyUSD = map[string]float64{
+	"t2.nano":   0.0058,
+	"t2.micro":  0.0116,
+	"t2.small":  0.023,
+	"t2.medium": 0.0464,
+	"t3.nano":   0.0052,
+	"t3.micro":  0.0104,
+	"t3.small":  0.0208,
+	"t3.medium": 0.0416,
+	"t3a.nano":  0.0047,
+	"t3a.micro": 0.0094,
+	"t3a.small": 0.0188,
+	"t4g.nano":  0.0042,
+	"t4g.micro": 0.0084,
+	"t4g.small": 0.0168,
+}
+
+// Estimate returns cost of the instance since it was launched, ok is false for instance types without known price
+func Estimate(instanceType string, launchedAt time.Time) (usd float64, ok bool) {
+	price, ok := hourlyUSD[instanceType]
+	if !ok {
+		return 0, false
+	}
+
+	return price * time.Since(launchedAt).Hours(), true
+}
@@ -0,0 +1,141 @@
+package creds
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// sources of AWS credentials
+const (
+	// FileSource reads static keys (and optional session token) from credentials file
+	FileSource = "file"
+	// ChainSource resolves credentials like AWS CLI does: environment variables, shared config and credentials files
+	// (profiles, SSO, credential_process, role_arn, mfa_serial), web identity, container and instance roles
+	ChainSource = "chain"
+)
+
+// stsRegion is used for STS when AWS config has no region, STS is global and serves every region there
+const stsRegion = "us-east-1"
+
+// AWS credential options, values are set by global flags and config file
+var (
+	AWSSource = FileSource
+	// AWSProfile is named profile of shared AWS config, empty means AWS_PROFILE or default profile, used by chain source
+	AWSProfile string
+	// AssumeRoleARN is role assumed with credentials of AWSSource, empty uses those credentials directly
+	AssumeRoleARN string
+)
+
+// MustBeValid panics if AWS credential options can't be used together
+func MustBeValid() {
+	if AWSSource != FileSource && AWSSource != ChainSource {
+		panic(tscerr.Errorf(tscerr.BadInput, "creds, invalid aws creds source; aws-creds=%s, allowed-aws-creds=%+v", AWSSource, []string{FileSource, ChainSource}))
+	}
+	if AWSProfile != "" && AWSSource != ChainSource {
+		panic(tscerr.Errorf(tscerr.BadInput, "creds, aws profile is read only by chain source, use --aws-creds=%s; aws-profile=%s", ChainSource, AWSProfile))
+	}
+}
+
+// AWS returns provider of AWS credentials for AWS clients. Credentials with expiry (session tokens, SSO, assumed role)
+// are cached and refreshed before they expire.
+func AWS(ctx context.Context) aws.CredentialsProvider {
+	return awsProvider(ctx, Get)
+}
+
+// awsProvider returns AWS provider, fileCreds reads credentials file for file source
+func awsProvider(ctx context.Context, fileCreds func() Creds) aws.CredentialsProvider {
+	var cfg aws.Config
+	if AWSSource == ChainSource {
+		cfg = loadChain(ctx)
+	} else {
+		crd := fileCreds()
+		if crd.AwsAccessKeyID == "" || crd.AwsSecretAccessKey == "" {
+			panic(tscerr.Errorf(tscerr.Auth, "creds, aws keys are not in credentials file, run creds set or use --aws-creds=%s; file=%s", ChainSource, tscos.CredsFile()))
+		}
+		cfg = aws.Config{
+			Region:      stsRegion,
+			Credentials: credentials.NewStaticCredentialsProvider(crd.AwsAccessKeyID, crd.AwsSecretAccessKey, crd.AwsSessionToken),
+		}
+	}
+
+	if AssumeRoleARN == "" {
+		return cfg.Credentials
+	}
+
+	return aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+		// session name shows in CloudTrail who used the role
+		o.RoleSessionName = "tscalectl-" + strconv.FormatInt(time.Now().Unix(), 10)
+	}))
+}
+
+func loadChain(ctx context.Context) aws.Config {
+	opts := []func(*config.LoadOptions) error{
+		config.WithDefaultRegion(stsRegion),
+		// profiles with mfa_serial ask for MFA code
+		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = mfaToken
+		}),
+	}
+	if AWSProfile != "" {
+		// AWS SDK falls back to other sources if profile is missing, mistyped profile is reported instead
+		if _, err := config.LoadSharedConfigProfile(ctx, AWSProfile); err != nil {
+			panic(tscerr.Wrapf(tscerr.BadInput, err, "creds, load aws profile; aws-profile=%s", AWSProfile))
+		}
+		opts = append(opts, config.WithSharedConfigProfile(AWSProfile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		panic(tscerr.Wrapf(tscerr.BadInput, err, "creds, load aws config; aws-profile=%s", AWSProfile))
+	}
+
+	return cfg
+}
+
+func mfaToken() (string, error) {
+	output.Progressf("MFA token code: ")
+	var code string
+	_, err := fmt.Fscanln(os.Stdin, &code)
+	if err != nil {
+		return "", tscerr.Wrap(tscerr.BadInput, err, "error scanning mfa token code")
+	}
+	return code, nil
+}
+
+// RetrieveAWS returns AWS credentials the way AWS clients get them
+func RetrieveAWS(ctx context.Context) aws.Credentials {
+	return retrieve(ctx, AWS(ctx))
+}
+
+// RetrieveAWSWithoutPrompt is RetrieveAWS which never asks for AWS keys (e.g. creds validate in CI),
+// keys missing in credentials file are an auth error
+func RetrieveAWSWithoutPrompt(ctx context.Context) aws.Credentials {
+	return retrieve(ctx, awsProvider(ctx, func() Creds {
+		crd, _ := Load()
+		return crd
+	}))
+}
+
+func retrieve(ctx context.Context, p aws.CredentialsProvider) aws.Credentials {
+	c, err := p.Retrieve(ctx)
+	if err != nil {
+		panic(tscerr.Wrapf(tscerr.Auth, err, "creds, failed to retrieve credentials; aws-creds=%s", AWSSource))
+	}
+	redact.Add(c.SecretAccessKey)
+	redact.Add(c.SessionToken)
+	return c
+}
@@ -2,85 +2,209 @@ package creds
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 
 	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/seal"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
 	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
 )
 
+// Creds are stored in credentials file, AWS keys are used only by file source of AWS credentials (see AWSSource)
 type Creds struct {
-	AwsAccessKeyID     string `json:"aws_access_key_id"`
-	AwsSecretAccessKey string `json:"aws_secret_access_key"`
-	TailscaleAuthKey   string `json:"tailscale_auth_key"`
+	AwsAccessKeyID     string `json:"aws_access_key_id,omitempty"`
+	AwsSecretAccessKey string `json:"aws_secret_access_key,omitempty"`
+	// AwsSessionToken is set with temporary AWS keys, e.g. ones printed by aws sts get-session-token
+	AwsSessionToken  string `json:"aws_session_token,omitempty"`
+	TailscaleAuthKey string `json:"tailscale_auth_key"`
+	// EncryptSSHKeys is set by creds encrypt --ssh-keys, private SSH keys are encrypted with passphrase of the file
+	EncryptSSHKeys bool `json:"encrypt_ssh_keys,omitempty"`
 }
 
+// TailscaleAuthKeyEnv is name of environment variable with tailscale auth key, empty reads it from credentials file
+var TailscaleAuthKeyEnv string
+
+// TailscaleAuthKey returns auth key which joins nodes into tailnet
+func TailscaleAuthKey() string {
+	if TailscaleAuthKeyEnv == "" {
+		key := get(false).TailscaleAuthKey
+		if key == "" {
+			panic(tscerr.Errorf(tscerr.Auth, "creds, tailscale auth key is not in credentials file, run creds set; file=%s", tscos.CredsFile()))
+		}
+		return key
+	}
+
+	key := os.Getenv(TailscaleAuthKeyEnv)
+	if key == "" {
+		panic(tscerr.Errorf(tscerr.Auth, "creds, tailscale auth key environment variable is not set; env=%s", TailscaleAuthKeyEnv))
+	}
+	redact.Add(key)
+	return key
+}
+
+// Require asks for credentials which are not set up yet, commands call it before other prompts, so they are not
+// interrupted by credential prompts later. Without cloudCreds (e.g. simulated cloud) only tailscale auth key is required.
+func Require(cloudCreds bool) {
+	if (cloudCreds && AWSSource == FileSource) || TailscaleAuthKeyEnv == "" {
+		get(cloudCreds)
+	}
+	TailscaleAuthKey()
+}
+
+// Get returns credentials from credentials file, user is asked for them if the file does not exist yet
 func Get() Creds {
-	fileutil.MkdirAllFromFile(tscos.CredsFile())
+	return get(true)
+}
 
-	unlock := fileutil.Lock(tscos.CredsFile())
+func get(awsKeys bool) Creds {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
 	defer unlock()
 
-	if _, err := os.Stat(tscos.CredsFile()); os.IsNotExist(err) {
-		return userInputCreds()
+	creds, ok := read()
+	if !ok {
+		return userInputCreds(awsKeys)
+	}
 
-	} else if err != nil {
-		panic(err)
-	} else {
-		b := fileutil.ReadFile(tscos.CredsFile())
-		if len(b) == 0 {
-			return userInputCreds()
-		}
+	// file was created for simulated cloud, AWS keys are asked for on the first use of AWS
+	if awsKeys && AWSSource == FileSource && creds.AwsAccessKeyID == "" && creds.AwsSecretAccessKey == "" {
+		output.Progressln("Your AWS credentials are not set up, please provide them")
+		PromptAWSKeys(&creds, false)
+		output.Progressln()
+		write(creds)
+	}
+	return creds
+}
 
-		var creds Creds
-		err = json.Unmarshal(b, &creds)
-		if err != nil {
-			panic(errors.Wrap(err, "json unmarshal creds file"))
-		}
+// Load returns credentials from credentials file without asking for missing ones, ok is false if the file does not
+// exist yet. Encrypted file is unlocked.
+func Load() (creds Creds, ok bool) {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
 
-		return creds
-	}
+	return read()
 }
 
-func userInputCreds() Creds {
-	fmt.Println("Your credentials are not set up, please provide them")
+// Update calls fn with credentials from credentials file (empty if there is no file) and stores changed credentials,
+// credentials file is locked meanwhile
+func Update(fn func(creds *Creds)) {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
 
-	fmt.Printf("aws_access_key_id: ")
-	var awsAccessKeyID string
-	_, err := fmt.Fscanln(os.Stdin, &awsAccessKeyID)
-	if err != nil {
-		panic(errors.Wrap(err, "error scanning aws access key id"))
+	creds, _ := read()
+	fn(&creds)
+	write(creds)
+}
+
+// Redact makes secrets of credentials file redacted in output which is not written by this process, e.g. printed logs.
+// Encrypted file is read only if it is unlocked without a prompt.
+func Redact() {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
+
+	b, ok := readFile()
+	if !ok {
+		return
 	}
+	if seal.IsSealed(b) {
+		key, ok := unlockKey(b, filepath.Base(tscos.CredsFile()), false)
+		if !ok {
+			return
+		}
+		b = key.Open(b)
+	}
+	redactCreds(decode(b))
+}
 
-	fmt.Printf("aws_secret_access_key: ")
-	var awsSecretAccessKey string
-	_, err = fmt.Fscanln(os.Stdin, &awsSecretAccessKey)
-	if err != nil {
-		panic(errors.Wrap(err, "error scanning aws secret access key"))
+// redactCreds keeps secrets out of logs and error output
+func redactCreds(creds Creds) {
+	redact.Add(creds.AwsSecretAccessKey)
+	redact.Add(creds.AwsSessionToken)
+	redact.Add(creds.TailscaleAuthKey)
+}
+
+// read returns credentials from credentials file, encrypted file is unlocked
+func read() (Creds, bool) {
+	b, ok := readFile()
+	if !ok {
+		return Creds{}, false
+	}
+	if seal.IsSealed(b) {
+		key, _ := unlockKey(b, filepath.Base(tscos.CredsFile()), true)
+		b = key.Open(b)
 	}
 
-	fmt.Printf("tailscale_auth_key: ")
-	var tailscaleAuthKey string
-	_, err = fmt.Fscanln(os.Stdin, &tailscaleAuthKey)
-	if err != nil {
-		panic(errors.Wrap(err, "error scanning tailscale auth key"))
+	creds := decode(b)
+	redactCreds(creds)
+	return creds, true
+}
+
+// readFile returns content of credentials file, ok is false if the file does not exist or is empty
+func readFile() ([]byte, bool) {
+	if _, err := os.Stat(tscos.CredsFile()); os.IsNotExist(err) {
+		return nil, false
+	} else if err != nil {
+		panic(err)
 	}
 
-	fmt.Println()
+	b := fileutil.ReadFile(tscos.CredsFile())
+	return b, len(b) > 0
+}
 
-	creds := Creds{
-		AwsAccessKeyID:     awsAccessKeyID,
-		AwsSecretAccessKey: awsSecretAccessKey,
-		TailscaleAuthKey:   tailscaleAuthKey,
+func decode(b []byte) Creds {
+	var creds Creds
+	if err := json.Unmarshal(b, &creds); err != nil {
+		panic(errors.Wrap(err, "json unmarshal creds file"))
 	}
+	return creds
+}
 
+// write stores credentials, encrypted file stays encrypted with the same passphrase
+func write(creds Creds) {
+	var key *seal.Key
+	if b, ok := readFile(); ok && seal.IsSealed(b) {
+		k, _ := unlockKey(b, filepath.Base(tscos.CredsFile()), true)
+		key = &k
+	}
+	writeWith(creds, key)
+}
+
+// writeWith stores credentials encrypted with key, nil key stores them in plain text
+func writeWith(creds Creds, key *seal.Key) {
 	credsBytes, err := json.Marshal(creds)
 	if err != nil {
 		panic(errors.Wrap(err, "json marshal creds"))
 	}
+	if key != nil {
+		credsBytes = key.Seal(credsBytes)
+	}
+	fileutil.MkdirAllFromFile(tscos.CredsFile())
 	fileutil.WriteFile(tscos.CredsFile(), credsBytes)
+}
+
+// userInputCreds asks for credentials, without awsKeys AWS keys are left empty (creds set adds them later)
+func userInputCreds(awsKeys bool) Creds {
+	output.Progressln("Your credentials are not set up, please provide them")
+
+	var creds Creds
+
+	// chain source finds AWS credentials on its own
+	if awsKeys && AWSSource == FileSource {
+		PromptAWSKeys(&creds, false)
+	}
+
+	// environment variable has tailscale auth key
+	if TailscaleAuthKeyEnv == "" {
+		PromptTailscaleAuthKey(&creds)
+	}
+
+	output.Progressln()
+
+	write(creds)
 
 	return creds
 }
@@ -0,0 +1,161 @@
+package creds
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/seal"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+const (
+	// PassphraseEnv is environment variable with passphrase of encrypted credentials, e.g. for scripts
+	PassphraseEnv = "TSCALECTL_PASSPHRASE"
+	// SessionEnv is environment variable with key printed by creds unlock, shell session keeps it instead of passphrase
+	SessionEnv = "TSCALECTL_SESSION"
+)
+
+const minPassphraseLength = 8
+
+var (
+	keysMu sync.Mutex
+	// keys are unlocked keys by their salt, passphrase is asked for once per process
+	keys = map[string]seal.Key{}
+)
+
+// unlockKey returns key which opens sealed data, name is shown in passphrase prompt. Key comes from SessionEnv,
+// PassphraseEnv or prompt, with interactive false ok is false instead of prompting.
+func unlockKey(sealed []byte, name string, interactive bool) (key seal.Key, ok bool) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	for _, k := range keys {
+		if k.Fits(sealed) {
+			return k, true
+		}
+	}
+
+	// session of another context (or of passphrase which was changed since) is skipped
+	if session := os.Getenv(SessionEnv); session != "" && seal.KeyFromSession(session, sealed).Fits(sealed) {
+		key = seal.KeyFromSession(session, sealed)
+	} else if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		redact.Add(passphrase)
+		key = seal.KeyFor(passphrase, sealed)
+	} else if interactive {
+		key = seal.KeyFor(prompt("passphrase of "+name, true, false), sealed)
+	} else {
+		return seal.Key{}, false
+	}
+
+	// wrong passphrase fails here, not later in the middle of a command
+	key.Open(sealed)
+	keys[key.Salt()] = key
+	redact.Add(key.Session())
+
+	return key, true
+}
+
+// Encrypted reports whether credentials file is encrypted
+func Encrypted() bool {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
+
+	b, ok := readFile()
+	return ok && seal.IsSealed(b)
+}
+
+// Encrypt stores credentials file encrypted with passphrase, encrypted file gets the new passphrase. With sshKeys
+// private SSH keys are encrypted too, once set it is kept until Decrypt. Returned key encrypts SSH keys.
+func Encrypt(passphrase string, sshKeys bool) (key seal.Key, encryptSSHKeys bool) {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
+
+	creds, ok := read()
+	if !ok {
+		panic(tscerr.Errorf(tscerr.NotFound, "creds encrypt, credentials file does not exist, run creds set first; file=%s", tscos.CredsFile()))
+	}
+	creds.EncryptSSHKeys = creds.EncryptSSHKeys || sshKeys
+
+	key = seal.NewKey(passphrase)
+	keysMu.Lock()
+	keys[key.Salt()] = key
+	keysMu.Unlock()
+	redact.Add(key.Session())
+
+	writeWith(creds, &key)
+
+	return key, creds.EncryptSSHKeys
+}
+
+// Decrypt stores credentials file in plain text, it must be followed by decrypting private SSH keys
+func Decrypt() {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
+
+	b, ok := readFile()
+	if !ok || !seal.IsSealed(b) {
+		panic(tscerr.Errorf(tscerr.BadInput, "creds decrypt, credentials file is not encrypted; file=%s", tscos.CredsFile()))
+	}
+
+	creds, _ := read()
+	creds.EncryptSSHKeys = false
+	writeWith(creds, nil)
+}
+
+// Unlock asks for passphrase of encrypted credentials file and returns its key
+func Unlock() seal.Key {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
+
+	b, ok := readFile()
+	if !ok || !seal.IsSealed(b) {
+		panic(tscerr.Errorf(tscerr.BadInput, "creds unlock, credentials file is not encrypted, run creds encrypt first; file=%s", tscos.CredsFile()))
+	}
+
+	key, _ := unlockKey(b, filepath.Base(tscos.CredsFile()), true)
+	return key
+}
+
+// SSHKeysKey returns key which encrypts new private SSH keys, ok is false if they are stored in plain text
+func SSHKeysKey() (key seal.Key, ok bool) {
+	unlock := fileutil.Lock(tscos.CredsLockFile())
+	defer unlock()
+
+	b, ok := readFile()
+	if !ok || !seal.IsSealed(b) {
+		return seal.Key{}, false
+	}
+
+	key, _ = unlockKey(b, filepath.Base(tscos.CredsFile()), true)
+	if !decode(key.Open(b)).EncryptSSHKeys {
+		return seal.Key{}, false
+	}
+	return key, true
+}
+
+// Open decrypts data encrypted with passphrase of credentials file (possibly an older one), name is shown in
+// passphrase prompt
+func Open(sealed []byte, name string) []byte {
+	key, _ := unlockKey(sealed, name, true)
+	return key.Open(sealed)
+}
+
+// PromptNewPassphrase returns PassphraseEnv, or asks for a new passphrase twice
+func PromptNewPassphrase() string {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		passphrase = prompt("new passphrase", true, false)
+		if prompt("repeat new passphrase", true, false) != passphrase {
+			panic(tscerr.New(tscerr.BadInput, "creds, passphrases do not match"))
+		}
+	}
+
+	if len(passphrase) < minPassphraseLength {
+		panic(tscerr.Errorf(tscerr.BadInput, "creds, passphrase is too short; min-length=%d", minPassphraseLength))
+	}
+	return passphrase
+}
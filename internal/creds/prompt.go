@@ -0,0 +1,89 @@
+package creds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// PromptAWSKeys asks for AWS access key, session token is asked for only if withSessionToken is set
+func PromptAWSKeys(creds *Creds, withSessionToken bool) {
+	creds.AwsAccessKeyID = prompt("aws_access_key_id", false, false)
+	creds.AwsSecretAccessKey = prompt("aws_secret_access_key", true, false)
+	creds.AwsSessionToken = ""
+	if withSessionToken {
+		creds.AwsSessionToken = prompt("aws_session_token (empty for long-term keys)", true, true)
+	}
+}
+
+// PromptTailscaleAuthKey asks for tailscale auth key and checks its format
+func PromptTailscaleAuthKey(creds *Creds) {
+	creds.TailscaleAuthKey = prompt("tailscale_auth_key", true, false)
+	MustBeValidTailscaleAuthKey(creds.TailscaleAuthKey)
+}
+
+// prompt asks for a value, hidden value is not echoed when stdin is a terminal. Prompt goes to stderr, so stdout of
+// e.g. creds unlock is only its result.
+func prompt(name string, hidden bool, optional bool) string {
+	fmt.Fprintf(os.Stderr, "%s: ", name)
+
+	var value string
+	fd := int(os.Stdin.Fd())
+	if hidden && term.IsTerminal(fd) {
+		// second Ctrl-C exits right away, terminal must not stay without echo
+		state, err := term.GetState(fd)
+		if err != nil {
+			panic(tscerr.Wrapf(tscerr.BadInput, err, "creds, get terminal state; name=%s", name))
+		}
+		unregister := interrupt.OnForceExit(func() { term.Restore(fd, state) })
+		defer unregister()
+
+		b, err := term.ReadPassword(fd)
+		// typed newline is not echoed either
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			panic(tscerr.Wrapf(tscerr.BadInput, err, "creds, read hidden input; name=%s", name))
+		}
+		value = string(b)
+	} else {
+		value = readLine(name)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" && !optional {
+		panic(tscerr.Errorf(tscerr.BadInput, "creds, value must not be empty; name=%s", name))
+	}
+	if hidden {
+		redact.Add(value)
+	}
+
+	return value
+}
+
+// readLine reads stdin byte by byte, so nothing is buffered away from following prompts
+func readLine(name string) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(line)
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			return string(line)
+		}
+		if err != nil {
+			panic(tscerr.Wrapf(tscerr.BadInput, err, "creds, read input; name=%s", name))
+		}
+	}
+}
@@ -2,32 +2,18 @@ package fileutil
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
-
-	"github.com/alexflint/go-filemutex"
 )
 
-func Lock(file string) func() {
-	m, err := filemutex.New(file)
-	if err != nil {
-		panic(err)
-	}
-
-	err = m.Lock()
-	if err != nil {
-		panic(err)
-	}
-
-	return func() {
-		errUnlock := m.Unlock()
-		if errUnlock != nil {
-			panic(err)
-		}
-	}
-}
+// CLI files hold credentials, state and private keys, only their owner may read them
+const (
+	FilePerm os.FileMode = 0600
+	DirPerm  os.FileMode = 0700
+)
 
 func MkdirAll(dirPath string) {
-	err := os.MkdirAll(dirPath, os.ModePerm)
+	err := os.MkdirAll(dirPath, DirPerm)
 	if err != nil {
 		panic(err)
 	}
@@ -39,14 +25,51 @@ func MkdirAllFromFile(filePath string) {
 }
 
 func WriteFile(filePath string, data []byte) {
-	WriteFilePerm(filePath, data, os.ModePerm)
+	WriteFilePerm(filePath, data, FilePerm)
 }
 
+// WriteFilePerm replaces file atomically. Data is written to a temporary file in the same directory, synced to disk
+// and renamed over the original file, so a crash leaves either the old or the new file, never a truncated one.
+// Rename replaces file inode, so callers have to lock a separate lock file, not the written file itself.
 func WriteFilePerm(filePath string, data []byte, perm os.FileMode) {
-	err := os.WriteFile(filePath, data, perm)
+	dir := filepath.Dir(filePath)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		panic(err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		panic(err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		panic(err)
+	}
+	if err = tmp.Close(); err != nil {
+		panic(err)
+	}
+
+	if err = os.Rename(tmp.Name(), filePath); err != nil {
+		panic(err)
+	}
+
+	// rename is durable only once the directory entry is synced
+	d, err := os.Open(dir)
 	if err != nil {
 		panic(err)
 	}
+	defer d.Close()
+
+	if err = d.Sync(); err != nil {
+		panic(err)
+	}
 }
 
 func ReadFile(filePath string) []byte {
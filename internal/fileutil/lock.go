@@ -0,0 +1,184 @@
+package fileutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-filemutex"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// LockTimeout is how long Lock waits for a lock held by another process, zero waits forever
+var LockTimeout = time.Second * 30
+
+// LockCommand describes this process in lock holder records, e.g. "tscalectl up"
+var LockCommand = filepath.Base(os.Args[0])
+
+// LockHolder is written to the holder file next to the lock file by the process holding the lock
+type LockHolder struct {
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (h LockHolder) String() string {
+	since := h.StartedAt.Local().Format("15:04")
+	if time.Since(h.StartedAt) > time.Hour*24 {
+		since = h.StartedAt.Local().Format("2006-01-02 15:04")
+	}
+
+	return fmt.Sprintf("`%s` pid %d since %s", h.Command, h.PID, since)
+}
+
+// Running reports whether holder process still exists
+func (h LockHolder) Running() bool {
+	return processRunning(h.PID)
+}
+
+// Lock acquires exclusive lock on the dedicated lock file (the file only guards other files, holder record is kept in
+// <file>.holder, on Windows the locked file can't be written or read through other handles). It panics if lock is not
+// acquired within LockTimeout.
+func Lock(file string) func() {
+	return LockContext(context.Background(), file)
+}
+
+// LockContext is Lock which stops waiting for the lock when ctx is canceled. Free lock is acquired even with
+// canceled ctx, so state can still be stored while command stops.
+func LockContext(ctx context.Context, file string) func() {
+	MkdirAllFromFile(file)
+
+	m, err := filemutex.New(file)
+	if err != nil {
+		panic(err)
+	}
+	// filemutex creates lock file readable by group
+	if err = os.Chmod(file, FilePerm); err != nil {
+		m.Close()
+		panic(err)
+	}
+
+	startTime := time.Now()
+	for {
+		err = m.TryLock()
+		if err == nil {
+			break
+		}
+		if err != filemutex.AlreadyLocked {
+			m.Close()
+			panic(err)
+		}
+
+		if LockTimeout > 0 && time.Since(startTime) > LockTimeout {
+			m.Close()
+			panic(lockTimeoutError(file))
+		}
+		select {
+		case <-time.After(time.Millisecond * 100):
+		case <-ctx.Done():
+			m.Close()
+			panic(interrupt.Err(ctx))
+		}
+	}
+
+	holder := LockHolder{PID: os.Getpid(), Command: LockCommand, StartedAt: time.Now()}
+	b, err := json.Marshal(holder)
+	if err != nil {
+		m.Close()
+		panic(err)
+	}
+	if err = os.WriteFile(holderFile(file), b, FilePerm); err != nil {
+		m.Close()
+		panic(err)
+	}
+
+	return func() {
+		// holder record is removed before the lock is released, so it never describes a process which doesn't hold
+		// the lock (record of the next holder is kept if this lock was force unlocked)
+		var current LockHolder
+		if json.Unmarshal(readHolder(holderFile(file)), &current) == nil && current.PID == holder.PID && current.StartedAt.Equal(holder.StartedAt) {
+			errRemove := os.Remove(holderFile(file))
+			if errRemove != nil && !os.IsNotExist(errRemove) {
+				m.Close()
+				panic(errRemove)
+			}
+		}
+
+		errUnlock := m.Close()
+		if errUnlock != nil {
+			panic(errUnlock)
+		}
+	}
+}
+
+// Holder returns holder of the lock, ok is false if lock is free
+func Holder(file string) (holder LockHolder, ok bool) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return LockHolder{}, false
+	}
+
+	m, err := filemutex.New(file)
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	err = m.TryLock()
+	if err == nil {
+		return LockHolder{}, false
+	}
+	if err != filemutex.AlreadyLocked {
+		panic(err)
+	}
+
+	// holder may not have written its record yet
+	_ = json.Unmarshal(readHolder(holderFile(file)), &holder)
+	return holder, true
+}
+
+// ForceUnlock removes the lock file and its holder record. Process holding the lock keeps its (now unreachable) lock,
+// next Lock callers create a new lock file and don't wait for it.
+func ForceUnlock(file string) {
+	for _, f := range []string{file, holderFile(file)} {
+		err := os.Remove(f)
+		if err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+	}
+}
+
+func holderFile(file string) string {
+	return file + ".holder"
+}
+
+// readHolder returns holder record, missing record is empty
+func readHolder(file string) []byte {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func lockTimeoutError(file string) error {
+	name := strings.TrimSuffix(filepath.Base(file), ".lock")
+
+	holder, ok := Holder(file)
+	if !ok {
+		return tscerr.Errorf(tscerr.Timeout, "%s lock was not acquired in %s; lock-file=%s", name, LockTimeout, file)
+	}
+	if holder.PID == 0 {
+		return tscerr.Errorf(tscerr.Timeout, "%s is locked by unknown process, lock was not acquired in %s; lock-file=%s", name, LockTimeout, file)
+	}
+	if !holder.Running() {
+		return tscerr.Errorf(tscerr.Timeout, "%s is locked by %s, which is not running anymore (stale holder record, lock is held by another process); lock-file=%s", name, holder, file)
+	}
+
+	return tscerr.Errorf(tscerr.Timeout, "%s is locked by %s, lock was not acquired in %s; lock-file=%s", name, holder, LockTimeout, file)
+}
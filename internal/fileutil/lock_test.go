@@ -0,0 +1,43 @@
+package fileutil
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLockHolder(t *testing.T) {
+	file := t.TempDir() + "/state.lock"
+
+	unlock := Lock(file)
+	holder, ok := Holder(file)
+	if !ok || holder.PID != os.Getpid() || holder.Command != LockCommand {
+		t.Fatalf("Holder() = %+v, %t, want this process", holder, ok)
+	}
+
+	unlock()
+	if _, ok = Holder(file); ok {
+		t.Fatalf("Holder() reports holder after unlock")
+	}
+	if _, err := os.Stat(holderFile(file)); !os.IsNotExist(err) {
+		t.Fatalf("holder record exists after unlock, stat error = %v", err)
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	file := t.TempDir() + "/state.lock"
+
+	hung := Lock(file)
+	ForceUnlock(file)
+
+	unlock := Lock(file)
+	defer unlock()
+
+	// hung process which finishes after force unlock keeps record of the new holder
+	hung()
+	if _, err := os.Stat(holderFile(file)); err != nil {
+		t.Fatalf("holder record of the new holder was removed, stat error = %v", err)
+	}
+	if _, ok := Holder(file); !ok {
+		t.Fatalf("Holder() reports no holder of the new lock")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package fileutil
+
+import "syscall"
+
+// processRunning sends signal 0, it only checks that process exists (EPERM means it belongs to another user)
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
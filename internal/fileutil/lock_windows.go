@@ -0,0 +1,23 @@
+//go:build windows
+
+package fileutil
+
+import "golang.org/x/sys/windows"
+
+// stillActive is exit code of process which did not exit yet (STILL_ACTIVE)
+const stillActive = 259
+
+// processRunning opens the process, access denied means it exists and belongs to another user
+func processRunning(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	if err = windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,55 @@
+package fileutil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LoosePerm is file or directory which group or other users can access
+type LoosePerm struct {
+	Path string
+	Perm os.FileMode
+}
+
+// FixPerms removes group and other permissions from everything under root, files created by older CLI versions were
+// world-readable. Fixed files and directories are returned, symlinks are skipped (chmod would change their target).
+func FixPerms(root string) []LoosePerm {
+	var fixed []LoosePerm
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// file was removed by another process meanwhile
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		perm := info.Mode().Perm()
+		if perm&0077 == 0 {
+			return nil
+		}
+		if err = os.Chmod(path, perm&^0077); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fixed = append(fixed, LoosePerm{Path: path, Perm: perm})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+
+	return fixed
+}
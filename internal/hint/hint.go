@@ -0,0 +1,218 @@
+package hint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// Hint explains a failure in plain language and suggests what to do next
+type Hint struct {
+	Explanation string
+	NextStep    string
+}
+
+func (h Hint) String() string {
+	return fmt.Sprintf("Hint: %s\nNext step: %s", h.Explanation, h.NextStep)
+}
+
+// apiHints are keyed by EC2 API error code, {operation} in texts is replaced by the failed API operation, e.g. RunInstances
+var apiHints = map[string]Hint{
+	"UnauthorizedOperation": {
+		Explanation: "AWS accepted your access key, but its IAM user is not allowed to call {operation}.",
+		NextStep:    "Allow `ec2:{operation}` in the IAM policy of the user. tscalectl needs ec2:Describe*, ec2:ImportKeyPair, ec2:DeleteKeyPair, ec2:CreateSecurityGroup, ec2:AuthorizeSecurityGroupIngress, ec2:DeleteSecurityGroup, ec2:RunInstances, ec2:CreateTags, ec2:TerminateInstances and ec2:GetConsoleOutput.",
+	},
+	"AuthFailure": {
+		Explanation: "AWS did not accept your access key.",
+		NextStep:    "Check the access key in the AWS console, then run `tscalectl creds rotate aws-access-key` and `tscalectl creds validate`.",
+	},
+	"InvalidClientTokenId": {
+		Explanation: "AWS access key ID does not exist (it was deleted or mistyped).",
+		NextStep:    "Run `tscalectl creds rotate aws-access-key` and enter an active access key.",
+	},
+	"SignatureDoesNotMatch": {
+		Explanation: "AWS secret access key does not belong to the access key ID.",
+		NextStep:    "Run `tscalectl creds rotate aws-access-key` and enter the secret access key again.",
+	},
+	"AccessDenied": {
+		Explanation: "AWS did not let your credentials assume the role given by --assume-role-arn.",
+		NextStep:    "Check the role ARN, and that trust policy of the role allows sts:AssumeRole for your user or role.",
+	},
+	"ExpiredToken": {
+		Explanation: "Your temporary AWS credentials (session token) expired.",
+		NextStep:    "Get new credentials (e.g. `aws sso login`) and run the command again, with --aws-creds=file replace the keys in the credentials file.",
+	},
+	"ExpiredTokenException": {
+		Explanation: "Your temporary AWS credentials (session token) expired.",
+		NextStep:    "Get new credentials (e.g. `aws sso login`) and run the command again, with --aws-creds=file replace the keys in the credentials file.",
+	},
+	"OptInRequired": {
+		Explanation: "The region is not enabled for your AWS account (regions launched after 2019 are disabled by default), or the account is not fully activated.",
+		NextStep:    "Enable the region in the AWS console (Account, AWS Regions), or choose another region with `-r`.",
+	},
+	"InvalidAMIID.NotFound": {
+		Explanation: "The AMI does not exist in this region. AMI IDs are different in every region.",
+		NextStep:    "Run `tscalectl up -i` without `-a` to choose one of the AMIs available in the region.",
+	},
+	"InvalidAMIID.Malformed": {
+		Explanation: "The AMI ID is not valid, it looks like ami-0440e5026412ff23f.",
+		NextStep:    "Run `tscalectl up -i` without `-a` to choose one of the AMIs available in the region.",
+	},
+	"InsufficientInstanceCapacity": {
+		Explanation: "AWS has no spare capacity for this instance type in the availability zone right now.",
+		NextStep:    "Retry in a few minutes, or try another instance type (`-t`) or region (`-r`), which places the instance into another availability zone.",
+	},
+	"Unsupported": {
+		Explanation: "The instance type is not offered in the availability zone or with this AMI.",
+		NextStep:    "Try another instance type (`-t`) or region (`-r`), `tscalectl up -i` lists instance types offered in the region.",
+	},
+	"VcpuLimitExceeded": {
+		Explanation: "Your account reached its vCPU quota for on-demand instances in this region.",
+		NextStep:    "Delete unused nodes (`tscalectl state list`, `tscalectl down`) and leftovers (`tscalectl gc`), choose a smaller instance type, or request a higher quota in Service Quotas (Running On-Demand Standard instances).",
+	},
+	"InstanceLimitExceeded": {
+		Explanation: "Your account reached its limit of running instances in this region.",
+		NextStep:    "Delete unused nodes (`tscalectl state list`, `tscalectl down`) and leftovers (`tscalectl gc`), or request a higher quota in Service Quotas.",
+	},
+	"SecurityGroupLimitExceeded": {
+		Explanation: "Your account reached its limit of security groups in this region.",
+		NextStep:    "Run `tscalectl gc` to delete security groups left behind by failed or interrupted commands.",
+	},
+	"KeyPairLimitExceeded": {
+		Explanation: "Your account reached its limit of key pairs in this region.",
+		NextStep:    "Run `tscalectl gc` to delete key pairs left behind by failed or interrupted commands.",
+	},
+	"InvalidKeyPair.Duplicate": {
+		Explanation: "A key pair with the node name already exists, it was left behind by an earlier node with the same ID.",
+		NextStep:    "Run `tscalectl gc` to delete it, then run the command again.",
+	},
+	"InvalidGroup.Duplicate": {
+		Explanation: "A security group with the node name already exists, it was left behind by an earlier node with the same ID.",
+		NextStep:    "Run `tscalectl gc` to delete it, then run the command again.",
+	},
+	"DependencyViolation": {
+		Explanation: "The security group is still attached to an instance which is shutting down.",
+		NextStep:    "Wait a minute and run the command again, or run `tscalectl gc` later to delete the leftover.",
+	},
+	"InvalidInstanceID.NotFound": {
+		Explanation: "The instance does not exist anymore (it was terminated outside of tscalectl).",
+		NextStep:    "Run `tscalectl state refresh` to find nodes whose instances are gone and prune them from state.",
+	},
+	"RequestLimitExceeded": {
+		Explanation: "AWS throttled API requests of your account.",
+		NextStep:    "Wait a minute and run the command again.",
+	},
+}
+
+// messageHints are matched against error messages, because SSH handshake flattens errors into strings.
+// Hint is used if error message contains all match strings. {known_hosts} and {key_pairs_dir} in texts are replaced
+// by paths when hint is used, key pairs dir depends on the --context flag which is not applied at init yet.
+var messageHints = []struct {
+	match []string
+	hint  Hint
+}{
+	{
+		match: []string{"knownhosts: key mismatch"},
+		hint: Hint{
+			Explanation: "The node presented a different host key than the one stored in {known_hosts}. AWS reuses public IPs, so another instance may have had this IP before, otherwise the connection may be intercepted.",
+			NextStep:    "If the node was recreated, remove the lines with its IP from {known_hosts} and run the command again. Otherwise do not connect.",
+		},
+	},
+	{
+		match: []string{"knownhosts: key is unknown"},
+		hint: Hint{
+			Explanation: "The node is not in {known_hosts}, so its host key can't be verified.",
+			NextStep:    "Run `tscalectl up --resume [nodeID]`, which records the host key before it connects.",
+		},
+	},
+	{
+		match: []string{"ssh", "unable to authenticate"},
+		hint: Hint{
+			Explanation: "The node rejected the SSH key.",
+			NextStep:    "Check that the key in {key_pairs_dir} is the one the node was created with. Nodes imported without their key can be reached only through tailnet.",
+		},
+	},
+	{
+		match: []string{"ssh", "connection refused"},
+		hint: Hint{
+			Explanation: "The node does not accept SSH connections yet (SSH server is still starting).",
+			NextStep:    "Wait a minute and run `tscalectl up --resume [nodeID]`.",
+		},
+	},
+	{
+		match: []string{"ssh", "i/o timeout"},
+		hint: Hint{
+			Explanation: "The node did not answer. It may still be booting, or its security group does not open the SSH port (nodes bootstrapped with cloud-init are reachable only through tailnet).",
+			NextStep:    "Wait a minute and run the command again, or check the node with `tscalectl state list`.",
+		},
+	},
+	{
+		match: []string{"seal, wrong passphrase"},
+		hint: Hint{
+			Explanation: "Credentials (or private SSH key) are encrypted with another passphrase, or TSCALECTL_PASSPHRASE / TSCALECTL_SESSION hold an old one.",
+			NextStep:    "Retry with the passphrase given to `tscalectl creds encrypt`, unset stale TSCALECTL_PASSPHRASE and run `eval \"$(tscalectl creds unlock)\"` again.",
+		},
+	},
+	{
+		match: []string{"failed to retrieve credentials"},
+		hint: Hint{
+			Explanation: "AWS SDK did not find credentials (environment variables, ~/.aws profiles, SSO and instance role were tried), or they could not be refreshed.",
+			NextStep:    "Check `aws sts get-caller-identity` (with the same --profile) works, run `aws sso login` if the profile uses SSO, or use --aws-creds=file.",
+		},
+	},
+	{
+		match: []string{"lock was not acquired"},
+		hint: Hint{
+			Explanation: "Another tscalectl command is using the same files.",
+			NextStep:    "Wait until it finishes. If it hung, stop it, or run `tscalectl state unlock --force`.",
+		},
+	},
+}
+
+// For returns hint for err, ok is false if there is no hint for it
+func For(err error) (h Hint, ok bool) {
+	if err == nil {
+		return Hint{}, false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if h, ok = apiHints[apiErr.ErrorCode()]; ok {
+			operation := "the operation"
+			var opErr *smithy.OperationError
+			if errors.As(err, &opErr) {
+				operation = opErr.Operation()
+			}
+			return Hint{
+				Explanation: strings.ReplaceAll(h.Explanation, "{operation}", operation),
+				NextStep:    strings.ReplaceAll(h.NextStep, "{operation}", operation),
+			}, true
+		}
+	}
+
+	msg := err.Error()
+	for _, m := range messageHints {
+		if containsAll(msg, m.match) {
+			r := strings.NewReplacer("{known_hosts}", tscos.KnownHostsFile(), "{key_pairs_dir}", tscos.AwsKeyPairsDir())
+			return Hint{
+				Explanation: r.Replace(m.hint.Explanation),
+				NextStep:    r.Replace(m.hint.NextStep),
+			}, true
+		}
+	}
+
+	return Hint{}, false
+}
+
+func containsAll(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package interrupt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+var (
+	mu     sync.Mutex
+	hooks  = make(map[int]func())
+	nextID int
+)
+
+// NotifyContext returns context which is canceled on the first interrupt (Ctrl-C or SIGTERM), so running command
+// can stop and clean up. On the second interrupt, functions registered with OnForceExit run and process exits right away.
+func NotifyContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping... (interrupt again to exit right away)")
+		cancel()
+
+		<-signals
+		fmt.Fprintln(os.Stderr, "\nInterrupted again, exiting")
+		runHooks()
+		os.Exit(tscerr.ExitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// OnForceExit registers fn which runs when process exits on the second interrupt (e.g. it prints resources which
+// were left behind), returned func unregisters it
+func OnForceExit(fn func()) func() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id := nextID
+	nextID++
+	hooks[id] = fn
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(hooks, id)
+	}
+}
+
+func runHooks() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// Err returns typed error of canceled ctx
+func Err(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return tscerr.Wrap(tscerr.Timeout, ctx.Err(), "deadline exceeded")
+	}
+	return tscerr.Wrap(tscerr.Interrupted, ctx.Err(), "interrupted")
+}
+
+// Check panics if ctx is canceled
+func Check(ctx context.Context) {
+	if ctx.Err() != nil {
+		panic(Err(ctx))
+	}
+}
+
+// Sleep pauses for d, it panics when ctx is canceled in the meantime
+func Sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		panic(Err(ctx))
+	}
+}
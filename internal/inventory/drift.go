@@ -0,0 +1,125 @@
+package inventory
+
+import (
+	"fmt"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+)
+
+// NodeCheck is the result of comparing node in state with its live resources
+type NodeCheck struct {
+	Node   *state.VPNNode
+	Status string
+	// Instance found for the node, nil if it is missing
+	Instance *provider.Instance
+	// SecurityGroup and KeyPair found for the node, nil if they are missing
+	SecurityGroup *provider.SecurityGroup
+	KeyPair       *provider.KeyPair
+	// Drift are differences between state and live resources
+	Drift []string
+	// Leftovers are resources of dead node which still exist
+	Leftovers []string
+}
+
+// Dead nodes have no instance left, only their leftovers can be cleaned up
+func (c NodeCheck) Dead() bool {
+	return c.Status == state.StatusTerminated || c.Status == state.StatusMissing
+}
+
+func CheckNode(node *state.VPNNode, res provider.Resources) NodeCheck {
+	c := NodeCheck{Node: node, Status: state.StatusMissing}
+
+	c.Instance = findInstance(node, res.Instances)
+	if c.Instance != nil {
+		c.Status = instanceStatus(c.Instance.State)
+	} else if !node.Done(state.StepInstanceLaunched) {
+		// instance was not launched yet, node is not dead
+		c.Status = state.StatusProvisioning
+	}
+
+	switch {
+	case c.Instance == nil && node.Done(state.StepInstanceLaunched):
+		c.Drift = append(c.Drift, "instance does not exist anymore")
+	case c.Status == state.StatusTerminated:
+		c.Drift = append(c.Drift, fmt.Sprintf("instance %s was terminated outside of tscalectl", c.Instance.ID))
+	case c.Status == state.StatusStopped:
+		c.Drift = append(c.Drift, fmt.Sprintf("instance %s is stopped", c.Instance.ID))
+	}
+	if c.Instance != nil && !c.Dead() && node.PublicIP != "" && c.Instance.PublicIP != node.PublicIP {
+		c.Drift = append(c.Drift, fmt.Sprintf("public IP changed %s -> %s", node.PublicIP, c.Instance.PublicIP))
+	}
+
+	sg, sgOK := findSecurityGroup(node, res.SecurityGroups)
+	if !sgOK && node.Done(state.StepSecurityGroupCreated) {
+		c.Drift = append(c.Drift, "security group does not exist anymore")
+	}
+	if sgOK {
+		c.SecurityGroup = &sg
+	}
+	if sgOK && c.Dead() {
+		c.Leftovers = append(c.Leftovers, fmt.Sprintf("security group %s", sg.ID))
+	}
+
+	kp, kpOK := findKeyPair(node, res.KeyPairs)
+	if !kpOK && node.Done(state.StepKeyImported) {
+		c.Drift = append(c.Drift, "key pair does not exist anymore")
+	}
+	if kpOK {
+		c.KeyPair = &kp
+	}
+	if kpOK && c.Dead() {
+		c.Leftovers = append(c.Leftovers, fmt.Sprintf("key pair %s", kp.ID))
+	}
+
+	return c
+}
+
+// findInstance matches instance ID stored in state, nodes created by older tscalectl versions are found by name
+func findInstance(node *state.VPNNode, instances []provider.Instance) *provider.Instance {
+	var byName *provider.Instance
+	for i, inst := range instances {
+		if node.InstanceID != "" {
+			if inst.ID == node.InstanceID {
+				return &instances[i]
+			}
+			continue
+		}
+		if inst.Name == node.TscalectlName && (byName == nil || instanceStatus(byName.State) == state.StatusTerminated) {
+			byName = &instances[i]
+		}
+	}
+	return byName
+}
+
+func findSecurityGroup(node *state.VPNNode, securityGroups []provider.SecurityGroup) (provider.SecurityGroup, bool) {
+	for _, sg := range securityGroups {
+		if (node.SecurityGroupID != "" && sg.ID == node.SecurityGroupID) || (node.SecurityGroupID == "" && sg.Name == node.TscalectlName) {
+			return sg, true
+		}
+	}
+	return provider.SecurityGroup{}, false
+}
+
+func findKeyPair(node *state.VPNNode, keyPairs []provider.KeyPair) (provider.KeyPair, bool) {
+	for _, kp := range keyPairs {
+		if (node.KeyPairID != "" && kp.ID == node.KeyPairID) || (node.KeyPairID == "" && kp.Name == node.TscalectlName) {
+			return kp, true
+		}
+	}
+	return provider.KeyPair{}, false
+}
+
+// instanceStatus maps cloud instance state to node status
+func instanceStatus(instanceState string) string {
+	switch instanceState {
+	case "pending", "running":
+		return state.StatusRunning
+	case "stopping", "stopped":
+		return state.StatusStopped
+	case "shutting-down", "terminated":
+		return state.StatusTerminated
+	default:
+		return state.StatusMissing
+	}
+}
@@ -0,0 +1,87 @@
+package inventory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+)
+
+// Region is a region of a provider
+type Region struct {
+	Provider string
+	Region   string
+}
+
+type Result struct {
+	Resources provider.Resources
+	// Err is set if region could not be scanned, other regions are scanned anyway
+	Err error
+}
+
+// Scan lists tscalectl managed resources in all regions concurrently
+func Scan(ctx context.Context, regions []Region) map[Region]Result {
+	results := make(map[Region]Result, len(regions))
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+
+	for _, r := range regions {
+		r := r
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var res Result
+			func() {
+				defer trycatch.ToError(&res.Err)
+				res.Resources = provider.New(r.Provider).ManagedResources(ctx, r.Region)
+			}()
+
+			mu.Lock()
+			results[r] = res
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// StateRegions returns distinct regions of nodes in state
+func StateRegions(s *state.State) []Region {
+	seen := make(map[Region]bool)
+	var regions []Region
+	for _, node := range s.Nodes {
+		r := Region{Provider: providerName(node.Provider), Region: node.Region}
+		if !seen[r] {
+			seen[r] = true
+			regions = append(regions, r)
+		}
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Provider != regions[j].Provider {
+			return regions[i].Provider < regions[j].Provider
+		}
+		return regions[i].Region < regions[j].Region
+	})
+
+	return regions
+}
+
+// NodeRegion is the region of node in scan results
+func NodeRegion(node *state.VPNNode) Region {
+	return Region{Provider: providerName(node.Provider), Region: node.Region}
+}
+
+// nodes created before providers were introduced have empty provider
+func providerName(name string) string {
+	if name == "" {
+		return provider.AWS
+	}
+	return name
+}
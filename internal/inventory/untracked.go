@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"sort"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// Group is a set of untracked resources sharing the same node name, or leftovers of a node marked for cleanup
+type Group struct {
+	Region        Region
+	Name          string
+	Instance      *provider.Instance
+	SecurityGroup *provider.SecurityGroup
+	KeyPair       *provider.KeyPair
+	// Node is set for dead node marked for cleanup by state refresh, it is removed from state with its leftovers
+	Node *state.VPNNode
+}
+
+// States returns s and local states of other contexts in config. Contexts can share AWS account, so resources which
+// node of any context references are not untracked.
+func States(s *state.State) []*state.State {
+	states := []*state.State{s}
+	for _, name := range config.Load().ContextNames() {
+		if name != tscos.Context {
+			states = append(states, state.ContextState(name))
+		}
+	}
+	return states
+}
+
+// Untracked returns managed resources of the region which no node in states references, grouped by node name.
+// Terminated instances are ignored, there is nothing left to do with them.
+func Untracked(states []*state.State, region Region, res provider.Resources) []Group {
+	var nodes []*state.VPNNode
+	for _, s := range states {
+		for _, node := range s.Nodes {
+			if NodeRegion(node) == region {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	groups := make(map[string]*Group)
+	group := func(name string) *Group {
+		g, ok := groups[name]
+		if !ok {
+			g = &Group{Region: region, Name: name}
+			groups[name] = g
+		}
+		return g
+	}
+
+	for i, inst := range res.Instances {
+		if instanceStatus(inst.State) == state.StatusTerminated || tracked(nodes, inst.ID, inst.Name, func(n *state.VPNNode) string { return n.InstanceID }) {
+			continue
+		}
+		group(inst.Name).Instance = &res.Instances[i]
+	}
+	for i, sg := range res.SecurityGroups {
+		if tracked(nodes, sg.ID, sg.Name, func(n *state.VPNNode) string { return n.SecurityGroupID }) {
+			continue
+		}
+		group(sg.Name).SecurityGroup = &res.SecurityGroups[i]
+	}
+	for i, kp := range res.KeyPairs {
+		if tracked(nodes, kp.ID, kp.Name, func(n *state.VPNNode) string { return n.KeyPairID }) {
+			continue
+		}
+		group(kp.Name).KeyPair = &res.KeyPairs[i]
+	}
+
+	untracked := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		untracked = append(untracked, *g)
+	}
+	sort.Slice(untracked, func(i, j int) bool {
+		return untracked[i].Name < untracked[j].Name
+	})
+
+	return untracked
+}
+
+// Marked returns leftovers of nodes of the region which state refresh marked for cleanup. Node which is not dead
+// anymore (e.g. its instance was found again) is skipped.
+func Marked(s *state.State, region Region, res provider.Resources) []Group {
+	var marked []Group
+	for _, node := range s.Nodes {
+		if !node.MarkedForCleanup || NodeRegion(node) != region {
+			continue
+		}
+
+		c := CheckNode(node, res)
+		if !c.Dead() {
+			continue
+		}
+		marked = append(marked, Group{Region: region, Name: node.TscalectlName, SecurityGroup: c.SecurityGroup, KeyPair: c.KeyPair, Node: node})
+	}
+	sort.Slice(marked, func(i, j int) bool {
+		return marked[i].Name < marked[j].Name
+	})
+
+	return marked
+}
+
+// tracked resource is referenced by its ID, nodes created by older tscalectl versions don't have IDs and
+// reference resources by node name
+func tracked(nodes []*state.VPNNode, id string, name string, nodeResourceID func(n *state.VPNNode) string) bool {
+	for _, n := range nodes {
+		if nodeResourceID(n) == id || (nodeResourceID(n) == "" && n.TscalectlName == name) {
+			return true
+		}
+	}
+	return false
+}
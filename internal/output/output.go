@@ -0,0 +1,118 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+const (
+	Text = "text"
+	// Table is the same as Text, commands print tables as their text output
+	Table = "table"
+	// Wide is Text with more columns, commands without tables print Text
+	Wide = "wide"
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// Format is the output format chosen with the global --output flag
+var Format = Text
+
+func Formats() []string {
+	return []string{Text, Table, Wide, JSON, YAML}
+}
+
+func MustBeValidFormat(format string) {
+	for _, f := range Formats() {
+		if f == format {
+			return
+		}
+	}
+	panic(tscerr.Errorf(tscerr.BadInput, "output, invalid output format; output=%s, allowed-outputs=%+v", format, Formats()))
+}
+
+// Structured reports whether command result is printed as JSON or YAML document
+func Structured() bool {
+	return Format == JSON || Format == YAML
+}
+
+// Result prints command result as JSON or YAML document to stdout
+func Result(v interface{}) {
+	Write(os.Stdout, Format, v)
+}
+
+// Write writes v as JSON or YAML document
+func Write(w io.Writer, format string, v interface{}) {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			panic(errors.Wrap(err, "output, json encode"))
+		}
+	case YAML:
+		// json tags are the documented schema, so document goes through json to get the same field names
+		jb, err := json.Marshal(v)
+		if err != nil {
+			panic(errors.Wrap(err, "output, json marshal"))
+		}
+		var doc interface{}
+		if err = yaml.Unmarshal(jb, &doc); err != nil {
+			panic(errors.Wrap(err, "output, yaml unmarshal"))
+		}
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			panic(errors.Wrap(err, "output, yaml marshal"))
+		}
+		fmt.Fprint(w, "---\n"+string(b))
+	default:
+		panic(errors.Errorf("output, format is not structured; output=%s", format))
+	}
+}
+
+// Progress messages and prompts go to stderr when result is structured, so stdout holds only the result document
+
+func ProgressWriter() io.Writer {
+	if Structured() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func Progressf(format string, a ...interface{}) {
+	fmt.Fprintf(ProgressWriter(), format, a...)
+}
+
+func Progressln(a ...interface{}) {
+	fmt.Fprintln(ProgressWriter(), a...)
+}
+
+func Progress(a ...interface{}) {
+	fmt.Fprint(ProgressWriter(), a...)
+}
+
+// PrintTable prints aligned columns to stdout, empty values are printed as "-"
+func PrintTable(headers []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, c := range row {
+			if c == "" {
+				c = "-"
+			}
+			cells[i] = c
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+}
@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/aws/ec2cli"
+)
+
+type awsProvider struct{}
+
+func (awsProvider) NeedsCloudCredentials() bool {
+	return true
+}
+
+func (awsProvider) Regions(ctx context.Context) []string {
+	return ec2cli.Regions(ctx)
+}
+
+func (awsProvider) InstanceTypesPerRegion(ctx context.Context, region string) []string {
+	return ec2cli.InstanceTypesPerRegion(ctx, region)
+}
+
+func (awsProvider) AMIsPerRegion(ctx context.Context, region string) []string {
+	return ec2cli.AMIsPerRegion(ctx, region)
+}
+
+func (awsProvider) ImportKeyPair(ctx context.Context, region string, keyName string, pubKey ssh.PublicKey) string {
+	return ec2cli.ImportKeyPair(ctx, region, keyName, pubKey)
+}
+
+func (awsProvider) CreateSecurityGroup(ctx context.Context, region string, securityGroupName string, sshIngress bool) string {
+	return ec2cli.CreateSecurityGroup(ctx, region, securityGroupName, sshIngress)
+}
+
+func (awsProvider) RunInstance(ctx context.Context, region string, instanceType string, ami string, vpnNodeName string, securityGroupID string, userData string) Instance {
+	return fromEC2Instance(ec2cli.RunInstance(ctx, region, instanceType, ami, vpnNodeName, securityGroupID, userData))
+}
+
+func (awsProvider) WaitForInstanceToInitialize(ctx context.Context, region string, instanceID string) {
+	ec2cli.WaitForInstanceToInitialize(ctx, region, instanceID)
+}
+
+func (awsProvider) ConsoleOutput(ctx context.Context, region string, instanceID string) string {
+	return ec2cli.ConsoleOutput(ctx, region, instanceID)
+}
+
+func (awsProvider) DescribeInstance(ctx context.Context, region string, instanceID string) Instance {
+	return fromEC2Instance(ec2cli.DescribeInstance(ctx, region, instanceID))
+}
+
+func (awsProvider) SSHAddress(instance Instance) string {
+	return instance.PublicIP
+}
+
+func (awsProvider) FindInstance(ctx context.Context, region string, vpnNodeName string) (Instance, bool) {
+	inst, ok := ec2cli.FindInstance(ctx, region, vpnNodeName)
+	return fromEC2Instance(inst), ok
+}
+
+func (awsProvider) FindSecurityGroup(ctx context.Context, region string, securityGroupName string) (string, bool) {
+	return ec2cli.FindSecurityGroup(ctx, region, securityGroupName)
+}
+
+func (awsProvider) FindKeyPair(ctx context.Context, region string, keyName string) (string, bool) {
+	return ec2cli.FindKeyPair(ctx, region, keyName)
+}
+
+func (awsProvider) ManagedResources(ctx context.Context, region string) Resources {
+	var res Resources
+	for _, inst := range ec2cli.ManagedInstances(ctx, region) {
+		res.Instances = append(res.Instances, fromEC2Instance(inst))
+	}
+	for _, sg := range ec2cli.ManagedSecurityGroups(ctx, region) {
+		res.SecurityGroups = append(res.SecurityGroups, fromEC2SecurityGroup(sg))
+	}
+	for _, kp := range ec2cli.ManagedKeyPairs(ctx, region) {
+		res.KeyPairs = append(res.KeyPairs, KeyPair{ID: aws.ToString(kp.KeyPairId), Name: aws.ToString(kp.KeyName), CreatedAt: aws.ToTime(kp.CreateTime)})
+	}
+	return res
+}
+
+func (awsProvider) TerminateInstance(ctx context.Context, region string, instanceID string) {
+	ec2cli.TerminateInstance(ctx, region, instanceID)
+}
+
+func (awsProvider) WaitForInstanceToTerminate(ctx context.Context, region string, instanceID string) {
+	ec2cli.WaitForInstanceToTerminate(ctx, region, instanceID)
+}
+
+func (awsProvider) DeleteSecurityGroup(ctx context.Context, region string, securityGroupID string) {
+	ec2cli.DeleteSecurityGroup(ctx, region, securityGroupID)
+}
+
+func (awsProvider) DeleteKeyPair(ctx context.Context, region string, keyPairID string) {
+	ec2cli.DeleteKeyPair(ctx, region, keyPairID)
+}
+
+func fromEC2Instance(inst types.Instance) Instance {
+	instance := Instance{
+		ID:           aws.ToString(inst.InstanceId),
+		InstanceType: string(inst.InstanceType),
+		AMI:          aws.ToString(inst.ImageId),
+		SubnetID:     aws.ToString(inst.SubnetId),
+		PublicIP:     aws.ToString(inst.PublicIpAddress),
+		PrivateIP:    aws.ToString(inst.PrivateIpAddress),
+		LaunchedAt:   aws.ToTime(inst.LaunchTime),
+	}
+
+	if inst.State != nil {
+		instance.State = string(inst.State.Name)
+	}
+	if inst.Placement != nil {
+		instance.AvailabilityZone = aws.ToString(inst.Placement.AvailabilityZone)
+	}
+	for _, tag := range inst.Tags {
+		if aws.ToString(tag.Key) == "Name" {
+			instance.Name = aws.ToString(tag.Value)
+		}
+	}
+
+	return instance
+}
+
+func fromEC2SecurityGroup(sg types.SecurityGroup) SecurityGroup {
+	securityGroup := SecurityGroup{
+		ID:   aws.ToString(sg.GroupId),
+		Name: aws.ToString(sg.GroupName),
+	}
+
+	for _, tag := range sg.Tags {
+		if aws.ToString(tag.Key) == ec2cli.CreatedAtTag {
+			securityGroup.CreatedAt, _ = time.Parse(time.RFC3339, aws.ToString(tag.Value))
+		}
+	}
+	for _, perm := range sg.IpPermissions {
+		if aws.ToInt32(perm.FromPort) <= 22 && aws.ToInt32(perm.ToPort) >= 22 {
+			securityGroup.SSHIngress = true
+		}
+	}
+
+	return securityGroup
+}
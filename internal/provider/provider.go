@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+const (
+	AWS      = "aws"
+	Simulate = "simulate"
+)
+
+// Provider is a cloud in which tscalectl provisions VPN nodes.
+type Provider interface {
+	// NeedsCloudCredentials reports whether provider calls cloud API with credentials of the context, simulated cloud
+	// works offline without them
+	NeedsCloudCredentials() bool
+
+	Regions(ctx context.Context) []string
+	InstanceTypesPerRegion(ctx context.Context, region string) []string
+	AMIsPerRegion(ctx context.Context, region string) []string
+
+	ImportKeyPair(ctx context.Context, region string, keyName string, pubKey ssh.PublicKey) string
+	// CreateSecurityGroup creates security group, sshIngress opens port 22 to the world. Group with the same name left
+	// by an earlier run is taken over.
+	CreateSecurityGroup(ctx context.Context, region string, securityGroupName string, sshIngress bool) string
+	// RunInstance launches instance, empty userData means instance is launched without cloud-init user data
+	RunInstance(ctx context.Context, region string, instanceType string, ami string, vpnNodeName string, securityGroupID string, userData string) Instance
+	WaitForInstanceToInitialize(ctx context.Context, region string, instanceID string)
+	ConsoleOutput(ctx context.Context, region string, instanceID string) string
+	DescribeInstance(ctx context.Context, region string, instanceID string) Instance
+	// SSHAddress returns address on which instance accepts SSH connections (host or host:port)
+	SSHAddress(instance Instance) string
+
+	// Find functions look up resources by their names, they are used only for nodes created by older
+	// tscalectl versions, which did not store resource IDs in CLI state
+	FindInstance(ctx context.Context, region string, vpnNodeName string) (Instance, bool)
+	FindSecurityGroup(ctx context.Context, region string, securityGroupName string) (string, bool)
+	FindKeyPair(ctx context.Context, region string, keyName string) (string, bool)
+
+	// ManagedResources lists every resource created by tscalectl in the region, whether it is in CLI state or not
+	ManagedResources(ctx context.Context, region string) Resources
+
+	TerminateInstance(ctx context.Context, region string, instanceID string)
+	WaitForInstanceToTerminate(ctx context.Context, region string, instanceID string)
+	DeleteSecurityGroup(ctx context.Context, region string, securityGroupID string)
+	DeleteKeyPair(ctx context.Context, region string, keyPairID string)
+}
+
+type Instance struct {
+	ID               string
+	Name             string
+	InstanceType     string
+	AMI              string
+	State            string
+	AvailabilityZone string
+	SubnetID         string
+	PublicIP         string
+	PrivateIP        string
+	LaunchedAt       time.Time
+}
+
+type SecurityGroup struct {
+	ID   string
+	Name string
+	// SSHIngress means port 22 is open to the world (node is bootstrapped over SSH)
+	SSHIngress bool
+	// CreatedAt is zero for AWS security groups created by older tscalectl versions
+	CreatedAt time.Time
+}
+
+type KeyPair struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// Resources are tscalectl managed resources in one region
+type Resources struct {
+	Instances      []Instance
+	SecurityGroups []SecurityGroup
+	KeyPairs       []KeyPair
+}
+
+func Names() []string {
+	return []string{AWS, Simulate}
+}
+
+// New returns provider by its name. Empty name is treated as AWS, because nodes created before
+// providers were introduced don't have provider stored in CLI state.
+func New(name string) Provider {
+	switch name {
+	case "", AWS:
+		return awsProvider{}
+	case Simulate:
+		return simulateProvider{}
+	default:
+		panic(tscerr.Errorf(tscerr.BadInput, "provider, unknown provider; provider=%s, allowed-providers=%+v", name, Names()))
+	}
+}
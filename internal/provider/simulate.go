@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/simulate"
+)
+
+type simulateProvider struct{}
+
+func (simulateProvider) NeedsCloudCredentials() bool {
+	return false
+}
+
+func (simulateProvider) Regions(ctx context.Context) []string {
+	return simulate.Regions()
+}
+
+func (simulateProvider) InstanceTypesPerRegion(ctx context.Context, region string) []string {
+	return simulate.InstanceTypesPerRegion(region)
+}
+
+func (simulateProvider) AMIsPerRegion(ctx context.Context, region string) []string {
+	return simulate.AMIsPerRegion(region)
+}
+
+func (simulateProvider) ImportKeyPair(ctx context.Context, region string, keyName string, pubKey ssh.PublicKey) string {
+	return simulate.ImportKeyPair(region, keyName, pubKey)
+}
+
+func (simulateProvider) CreateSecurityGroup(ctx context.Context, region string, securityGroupName string, sshIngress bool) string {
+	return simulate.CreateSecurityGroup(region, securityGroupName, sshIngress)
+}
+
+func (simulateProvider) RunInstance(ctx context.Context, region string, instanceType string, ami string, vpnNodeName string, securityGroupID string, userData string) Instance {
+	return fromSimulatedInstance(simulate.RunInstance(region, instanceType, ami, vpnNodeName, securityGroupID, userData))
+}
+
+func (simulateProvider) WaitForInstanceToInitialize(ctx context.Context, region string, instanceID string) {
+	simulate.WaitForInstanceToInitialize(ctx, region, instanceID)
+}
+
+func (simulateProvider) ConsoleOutput(ctx context.Context, region string, instanceID string) string {
+	return simulate.ConsoleOutput(region, instanceID)
+}
+
+func (simulateProvider) DescribeInstance(ctx context.Context, region string, instanceID string) Instance {
+	return fromSimulatedInstance(simulate.DescribeInstance(region, instanceID))
+}
+
+func (simulateProvider) SSHAddress(instance Instance) string {
+	return simulate.SSHAddress()
+}
+
+func (simulateProvider) FindInstance(ctx context.Context, region string, vpnNodeName string) (Instance, bool) {
+	inst, ok := simulate.FindInstance(region, vpnNodeName)
+	return fromSimulatedInstance(inst), ok
+}
+
+func (simulateProvider) FindSecurityGroup(ctx context.Context, region string, securityGroupName string) (string, bool) {
+	return simulate.FindSecurityGroup(region, securityGroupName)
+}
+
+func (simulateProvider) FindKeyPair(ctx context.Context, region string, keyName string) (string, bool) {
+	return simulate.FindKeyPair(region, keyName)
+}
+
+func (simulateProvider) ManagedResources(ctx context.Context, region string) Resources {
+	var res Resources
+	for _, inst := range simulate.ManagedInstances(region) {
+		res.Instances = append(res.Instances, fromSimulatedInstance(inst))
+	}
+	for _, sg := range simulate.ManagedSecurityGroups(region) {
+		res.SecurityGroups = append(res.SecurityGroups, SecurityGroup{ID: sg.ID, Name: sg.Name, SSHIngress: sg.SSHIngress, CreatedAt: sg.CreatedAt})
+	}
+	for _, kp := range simulate.ManagedKeyPairs(region) {
+		res.KeyPairs = append(res.KeyPairs, KeyPair{ID: kp.ID, Name: kp.Name, CreatedAt: kp.CreatedAt})
+	}
+	return res
+}
+
+func (simulateProvider) TerminateInstance(ctx context.Context, region string, instanceID string) {
+	simulate.TerminateInstance(region, instanceID)
+}
+
+func (simulateProvider) WaitForInstanceToTerminate(ctx context.Context, region string, instanceID string) {
+	simulate.WaitForInstanceToTerminate(region, instanceID)
+}
+
+func (simulateProvider) DeleteSecurityGroup(ctx context.Context, region string, securityGroupID string) {
+	simulate.DeleteSecurityGroup(region, securityGroupID)
+}
+
+func (simulateProvider) DeleteKeyPair(ctx context.Context, region string, keyPairID string) {
+	simulate.DeleteKeyPair(region, keyPairID)
+}
+
+func fromSimulatedInstance(inst simulate.Instance) Instance {
+	return Instance{
+		ID:               inst.ID,
+		Name:             inst.Name,
+		InstanceType:     inst.InstanceType,
+		AMI:              inst.AMI,
+		State:            inst.State,
+		AvailabilityZone: inst.AvailabilityZone,
+		SubnetID:         inst.SubnetID,
+		PublicIP:         inst.PublicIP,
+		PrivateIP:        inst.PrivateIP,
+		LaunchedAt:       inst.LaunchedAt,
+	}
+}
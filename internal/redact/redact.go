@@ -0,0 +1,86 @@
+package redact
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// tailscale keys (auth keys, OAuth client secrets, API access tokens) are recognized by their format
+var tailscaleKeyPattern = regexp.MustCompile(`tskey-[A-Za-z0-9-]+`)
+
+// minSecretLength keeps short values (e.g. mistyped prompt input) from redacting unrelated text
+const minSecretLength = 8
+
+var (
+	mu sync.Mutex
+	// secrets have no recognizable format (e.g. AWS secret access key), code which reads them adds them with Add
+	secrets []string
+)
+
+// Add makes secret redacted in everything which goes through String and Writer
+func Add(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// String returns s with tailscale keys and added secrets replaced by ****
+func String(s string) string {
+	s = tailscaleKeyPattern.ReplaceAllString(s, "tskey-****")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "****")
+	}
+	return s
+}
+
+// Writer redacts lines written to w, so a secret is never split between writes. Incomplete last line is written
+// by Close.
+func Writer(w io.Writer) io.WriteCloser {
+	return &writer{w: w}
+}
+
+type writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	i := bytes.LastIndexByte(w.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(w.w, String(string(w.buf[:i+1]))); err != nil {
+		return 0, err
+	}
+	w.buf = w.buf[i+1:]
+
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, String(string(w.buf)))
+	w.buf = nil
+	return err
+}
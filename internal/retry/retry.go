@@ -0,0 +1,161 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+// shared retry policy, values are set by global flags and config file
+var (
+	// Attempts is how many times EC2 calls are attempted, 1 disables retries
+	Attempts = 5
+	// BaseDelay is the wait before the second attempt, it doubles with every attempt up to MaxDelay
+	BaseDelay = time.Second
+	MaxDelay  = time.Second * 20
+
+	// deadlines of a single attempt
+	EC2Timeout        = time.Second * 20
+	SSHDialTimeout    = time.Second * 10
+	SSHCommandTimeout = time.Minute * 10
+
+	// SSHReadyTimeout is how long SSH dials are retried while instance is booting, it replaces Attempts for them
+	SSHReadyTimeout = time.Minute * 3
+)
+
+// MustBeValid panics if shared retry policy can't be used
+func MustBeValid() {
+	if Attempts < 1 {
+		panic(tscerr.Errorf(tscerr.BadInput, "retry, attempts must be at least 1; attempts=%d", Attempts))
+	}
+	if BaseDelay < 0 || MaxDelay < BaseDelay {
+		panic(tscerr.Errorf(tscerr.BadInput, "retry, delays must be positive and max delay at least base delay; base=%s, max=%s", BaseDelay, MaxDelay))
+	}
+	for _, d := range []time.Duration{EC2Timeout, SSHDialTimeout, SSHCommandTimeout, SSHReadyTimeout} {
+		if d < 0 {
+			panic(tscerr.Errorf(tscerr.BadInput, "retry, timeouts must not be negative; timeout=%s", d))
+		}
+	}
+}
+
+// Policy tells how Do retries an operation
+type Policy struct {
+	// Attempts limits number of attempts, zero means no limit (then MaxElapsed should be set)
+	Attempts int
+	// MaxElapsed stops retries once that much time passed since the first attempt, zero means no limit
+	MaxElapsed time.Duration
+	// Timeout is deadline of a single attempt, zero means no deadline
+	Timeout time.Duration
+}
+
+// EC2 is policy of EC2 API calls
+func EC2() Policy {
+	return Policy{Attempts: Attempts, Timeout: EC2Timeout}
+}
+
+// SSHDial is policy of SSH dials, they are retried until instance accepts connections or SSHReadyTimeout passes
+func SSHDial() Policy {
+	return Policy{MaxElapsed: SSHReadyTimeout, Timeout: SSHDialTimeout}
+}
+
+// Do calls fn until it succeeds, returns error which is not retryable, or policy gives up, and returns the last
+// error. Waits between attempts grow exponentially and are jittered, so parallel commands don't retry in lockstep.
+// Canceled ctx stops retries right away.
+func Do(ctx context.Context, p Policy, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := try(ctx, p.Timeout, fn)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return interrupt.Err(ctx)
+		}
+		if !retryable(err) || (p.Attempts > 0 && attempt >= p.Attempts) {
+			return err
+		}
+
+		delay := Backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return err
+		}
+
+		tsclog.Warnf("Attempt %d failed, retrying in %s: %v", attempt, delay.Round(time.Millisecond*100), err)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return interrupt.Err(ctx)
+		}
+	}
+}
+
+func try(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return fn(ctx)
+}
+
+// Backoff returns wait after failed attempt, it is a random duration up to BaseDelay*2^(attempt-1) capped by MaxDelay
+// (full jitter), but at least a tenth of it
+func Backoff(attempt int) time.Duration {
+	d := MaxDelay
+	if attempt < 32 && BaseDelay<<(attempt-1) < MaxDelay {
+		d = BaseDelay << (attempt - 1)
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/10 + time.Duration(rand.Int63n(int64(d-d/10)+1))
+}
+
+// Transient reports whether err is a network failure which may go away on its own (timeout, refused or reset
+// connection, unreachable host, connection closed by peer)
+func Transient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	for _, target := range []error{
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.ECONNABORTED,
+		syscall.EHOSTUNREACH,
+		syscall.ENETUNREACH,
+		syscall.EPIPE,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
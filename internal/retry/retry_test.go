@@ -0,0 +1,163 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+func withDelays(t *testing.T, base time.Duration, max time.Duration) {
+	t.Helper()
+
+	oldBase, oldMax := BaseDelay, MaxDelay
+	BaseDelay, MaxDelay = base, max
+	t.Cleanup(func() {
+		BaseDelay, MaxDelay = oldBase, oldMax
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	withDelays(t, time.Second, time.Second*20)
+
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{attempt: 1, ceiling: time.Second},
+		{attempt: 2, ceiling: time.Second * 2},
+		{attempt: 3, ceiling: time.Second * 4},
+		{attempt: 5, ceiling: time.Second * 16},
+		{attempt: 6, ceiling: time.Second * 20},
+		// shift overflows without the cap
+		{attempt: 64, ceiling: time.Second * 20},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := Backoff(tt.attempt)
+			if d < tt.ceiling/10 || d > tt.ceiling {
+				t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempt, d, tt.ceiling/10, tt.ceiling)
+			}
+		}
+	}
+}
+
+func TestBackoffZeroDelay(t *testing.T) {
+	withDelays(t, 0, 0)
+
+	if d := Backoff(3); d != 0 {
+		t.Fatalf("Backoff(3) = %s, want 0", d)
+	}
+}
+
+func TestDo(t *testing.T) {
+	withDelays(t, time.Millisecond, time.Millisecond*2)
+
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+	retryable := func(err error) bool { return err == errTransient }
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		errs     []error
+		wantErr  error
+		wantCall int
+	}{
+		{name: "success", policy: Policy{Attempts: 3}, errs: []error{nil}, wantCall: 1},
+		{name: "success after retries", policy: Policy{Attempts: 3}, errs: []error{errTransient, errTransient, nil}, wantCall: 3},
+		{name: "attempts exhausted", policy: Policy{Attempts: 2}, errs: []error{errTransient, errTransient, nil}, wantErr: errTransient, wantCall: 2},
+		{name: "not retryable", policy: Policy{Attempts: 3}, errs: []error{errFatal, nil}, wantErr: errFatal, wantCall: 1},
+		{name: "single attempt", policy: Policy{Attempts: 1}, errs: []error{errTransient, nil}, wantErr: errTransient, wantCall: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Do(context.Background(), tt.policy, retryable, func(ctx context.Context) error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+
+			if err != tt.wantErr {
+				t.Fatalf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCall {
+				t.Fatalf("Do() called fn %d times, want %d", calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestDoMaxElapsed(t *testing.T) {
+	withDelays(t, time.Millisecond*20, time.Millisecond*20)
+
+	calls := 0
+	start := time.Now()
+	err := Do(context.Background(), Policy{MaxElapsed: time.Millisecond * 100}, func(error) bool { return true }, func(ctx context.Context) error {
+		calls++
+		return syscall.ECONNREFUSED
+	})
+
+	if err != syscall.ECONNREFUSED {
+		t.Fatalf("Do() error = %v, want %v", err, syscall.ECONNREFUSED)
+	}
+	if calls < 2 {
+		t.Fatalf("Do() called fn %d times, want retries until max elapsed", calls)
+	}
+	// no wait would end after max elapsed, timers may fire a bit late
+	if elapsed := time.Since(start); elapsed > time.Millisecond*150 {
+		t.Fatalf("Do() took %s, want about max elapsed", elapsed)
+	}
+}
+
+func TestDoAttemptTimeout(t *testing.T) {
+	err := Do(context.Background(), Policy{Attempts: 1, Timeout: time.Millisecond}, Transient, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do() error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	withDelays(t, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Do(ctx, Policy{Attempts: 5}, func(error) bool { return true }, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return errors.New("failed")
+	})
+
+	if !tscerr.Is(err, tscerr.Interrupted) {
+		t.Fatalf("Do() error = %v, want interrupted", err)
+	}
+	if calls != 1 {
+		t.Fatalf("Do() called fn %d times, want 1", calls)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: context.DeadlineExceeded, want: true},
+		{err: syscall.ECONNREFUSED, want: true},
+		{err: tscerr.Wrap(tscerr.Unknown, syscall.ECONNRESET, "ssh dial"), want: true},
+		{err: errors.New("unable to authenticate"), want: false},
+		{err: context.Canceled, want: false},
+	}
+	for _, tt := range tests {
+		if got := Transient(tt.err); got != tt.want {
+			t.Errorf("Transient(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package rollback
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+// Rollback remembers resources in order of their creation and undoes them in reverse order.
+type Rollback struct {
+	// Hint is printed when some resources are left behind, it should tell user how to clean them up
+	Hint string
+
+	// mu guards steps, leftovers can be printed from signal handler while rollback runs
+	mu    sync.Mutex
+	steps []step
+}
+
+type step struct {
+	resource string
+	undo     func()
+}
+
+func New() *Rollback {
+	return &Rollback{}
+}
+
+// Add records created resource (e.g. "EC2 key pair") and function which deletes it
+func (r *Rollback) Add(resource string, undo func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.steps = append(r.steps, step{resource: resource, undo: undo})
+}
+
+// OnPanic must be deferred. If surrounding function panics (e.g. it was interrupted), created resources are undone
+// (unless keep is set) and panic is propagated further.
+func (r *Rollback) OnPanic(keep bool) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+
+	reason := "Failure"
+	if err, ok := rec.(error); ok && tscerr.Is(err, tscerr.Interrupted) {
+		reason = "Interrupted"
+	}
+
+	if keep {
+		tsclog.Infof("%s, keeping created resources", reason)
+		r.PrintLeftovers()
+	} else {
+		tsclog.Infof("%s, rolling back created resources", reason)
+		r.Run()
+	}
+
+	panic(rec)
+}
+
+// Run undoes steps in reverse order. It stops on the first failed step, because resources created earlier
+// (e.g. security group, CLI state entry) are needed for cleaning up the failed one later.
+func (r *Rollback) Run() bool {
+	for {
+		r.mu.Lock()
+		if len(r.steps) == 0 {
+			r.mu.Unlock()
+			return true
+		}
+		s := r.steps[len(r.steps)-1]
+		r.mu.Unlock()
+
+		if err := s.run(); err != nil {
+			tsclog.Warnf("Rollback of %s failed: %s", s.resource, err)
+			r.PrintLeftovers()
+			return false
+		}
+		tsclog.Infof("Rolled back %s", s.resource)
+
+		r.mu.Lock()
+		r.steps = r.steps[:len(r.steps)-1]
+		r.mu.Unlock()
+	}
+}
+
+// PrintLeftovers prints resources which were not undone yet
+func (r *Rollback) PrintLeftovers() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.steps) == 0 {
+		return
+	}
+
+	tsclog.Warnf("Resources left behind:")
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		tsclog.Warnf("  - %s", r.steps[i].resource)
+	}
+	if r.Hint != "" {
+		tsclog.Warnf("%s", r.Hint)
+	}
+}
+
+func (s step) run() (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errors.Errorf("%v", rec)
+		}
+	}()
+
+	s.undo()
+	return nil
+}
@@ -0,0 +1,170 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// Format names key derivation and cipher of sealed data, it marks JSON document as sealed
+const Format = "scrypt-xchacha20poly1305"
+
+// scrypt parameters recommended for interactive logins, derivation takes ~100ms
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	saltLen   = 16
+	keyLength = chacha20poly1305.KeySize
+)
+
+// box is sealed data as it is stored, []byte fields are base64 in JSON
+type box struct {
+	Format     string `json:"tscalectl_sealed"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Key is derived from passphrase and salt, data sealed with the key carries the salt, so passphrase alone opens it
+type Key struct {
+	salt []byte
+	n    int
+	r    int
+	p    int
+	key  []byte
+}
+
+// NewKey derives key from passphrase with a new random salt
+func NewKey(passphrase string) Key {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		panic(errors.Wrap(err, "seal, random salt"))
+	}
+	return derive(passphrase, salt, scryptN, scryptR, scryptP)
+}
+
+// KeyFor derives key which opens sealed data from passphrase
+func KeyFor(passphrase string, sealed []byte) Key {
+	b := parse(sealed)
+	return derive(passphrase, b.Salt, b.N, b.R, b.P)
+}
+
+func derive(passphrase string, salt []byte, n, r, p int) Key {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, keyLength)
+	if err != nil {
+		panic(tscerr.Wrapf(tscerr.BadInput, err, "seal, derive key; n=%d, r=%d, p=%d", n, r, p))
+	}
+	return Key{salt: salt, n: n, r: r, p: p, key: key}
+}
+
+// Salt identifies passphrase and parameters of the key, keys with the same salt are the same key
+func (k Key) Salt() string {
+	return base64.RawStdEncoding.EncodeToString(k.salt)
+}
+
+// Fits reports whether data was sealed with key of the same salt
+func (k Key) Fits(sealed []byte) bool {
+	return subtle.ConstantTimeCompare(parse(sealed).Salt, k.salt) == 1
+}
+
+// Seal encrypts and authenticates data, result is JSON document
+func (k Key) Seal(data []byte) []byte {
+	aead, err := chacha20poly1305.NewX(k.key)
+	if err != nil {
+		panic(errors.Wrap(err, "seal, new cipher"))
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		panic(errors.Wrap(err, "seal, random nonce"))
+	}
+
+	b := box{Format: Format, N: k.n, R: k.r, P: k.p, Salt: k.salt, Nonce: nonce}
+	b.Ciphertext = aead.Seal(nil, nonce, data, []byte(Format))
+
+	sealed, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		panic(errors.Wrap(err, "seal, json marshal"))
+	}
+	return sealed
+}
+
+// Open decrypts sealed data, it panics with Auth error if key is wrong or data was changed
+func (k Key) Open(sealed []byte) []byte {
+	b := parse(sealed)
+
+	aead, err := chacha20poly1305.NewX(k.key)
+	if err != nil {
+		panic(errors.Wrap(err, "seal, new cipher"))
+	}
+	if len(b.Nonce) != aead.NonceSize() {
+		panic(tscerr.Errorf(tscerr.BadInput, "seal, sealed data is damaged, invalid nonce; nonce-length=%d", len(b.Nonce)))
+	}
+
+	data, err := aead.Open(nil, b.Nonce, b.Ciphertext, []byte(Format))
+	if err != nil {
+		panic(tscerr.New(tscerr.Auth, "seal, wrong passphrase (or sealed data was changed)"))
+	}
+	return data
+}
+
+// Session encodes key, so it can be kept by a shell session instead of the passphrase
+func (k Key) Session() string {
+	return k.Salt() + "." + base64.RawStdEncoding.EncodeToString(k.key)
+}
+
+// KeyFromSession decodes key encoded by Session, sealed is data which the key opens
+func KeyFromSession(session string, sealed []byte) Key {
+	salt, key, ok := strings.Cut(session, ".")
+	if !ok {
+		panic(tscerr.New(tscerr.BadInput, "seal, invalid session, it must be salt.key"))
+	}
+	// scrypt parameters are needed to seal new data which the passphrase must open as well
+	b := parse(sealed)
+	k := Key{salt: decode(salt), n: b.N, r: b.R, p: b.P, key: decode(key)}
+	if len(k.key) != keyLength {
+		panic(tscerr.Errorf(tscerr.BadInput, "seal, invalid session key; key-length=%d", len(k.key)))
+	}
+	return k
+}
+
+func decode(s string) []byte {
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		panic(tscerr.Wrap(tscerr.BadInput, err, "seal, invalid session, base64 decode"))
+	}
+	return b
+}
+
+// IsSealed reports whether data is sealed JSON document
+func IsSealed(data []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return false
+	}
+	var b box
+	return json.Unmarshal(data, &b) == nil && b.Format != ""
+}
+
+func parse(sealed []byte) box {
+	var b box
+	if err := json.Unmarshal(sealed, &b); err != nil {
+		panic(errors.Wrap(err, "seal, json unmarshal"))
+	}
+	if b.Format != Format {
+		panic(tscerr.Errorf(tscerr.BadInput, "seal, unsupported format; format=%s, supported-format=%s", b.Format, Format))
+	}
+	return b
+}
@@ -0,0 +1,460 @@
+package simulate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/cloudinit"
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// Simulated cloud keeps its resources in a JSON file, so resources created by one tscalectl
+// process (e.g. up) are visible to the next one (e.g. ssh or down).
+
+const (
+	instanceStatePending    = "pending"
+	instanceStateRunning    = "running"
+	instanceStateTerminated = "terminated"
+)
+
+type cloud struct {
+	Regions map[string]*region `json:"regions"`
+}
+
+type region struct {
+	KeyPairs       map[string]*KeyPair       `json:"key_pairs"`
+	SecurityGroups map[string]*SecurityGroup `json:"security_groups"`
+	Instances      map[string]*Instance      `json:"instances"`
+}
+
+type KeyPair struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SecurityGroup struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	SSHIngress bool      `json:"ssh_ingress"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Instance struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	InstanceType     string    `json:"instance_type"`
+	AMI              string    `json:"ami"`
+	KeyName          string    `json:"key_name"`
+	SecurityGroupID  string    `json:"security_group_id"`
+	AvailabilityZone string    `json:"availability_zone"`
+	SubnetID         string    `json:"subnet_id"`
+	PublicIP         string    `json:"public_ip"`
+	PrivateIP        string    `json:"private_ip"`
+	UserData         string    `json:"user_data,omitempty"`
+	State            string    `json:"state"`
+	LaunchedAt       time.Time `json:"launched_at"`
+	Commands         []string  `json:"commands,omitempty"`
+}
+
+var regions = []string{"eu-central-1", "eu-north-1", "eu-west-1", "us-east-1", "us-west-2"}
+var instanceTypes = []string{"t2.micro", "t3.micro", "t3.small"}
+var amis = []string{"ami-0000000000000simu"}
+
+func Regions() []string {
+	regionNames := append([]string{}, regions...)
+	sort.Strings(regionNames)
+	return regionNames
+}
+
+func InstanceTypesPerRegion(region string) []string {
+	mustBeValidRegion(region)
+	return append([]string{}, instanceTypes...)
+}
+
+func AMIsPerRegion(region string) []string {
+	mustBeValidRegion(region)
+	return append([]string{}, amis...)
+}
+
+func ImportKeyPair(region string, keyName string, pubKey ssh.PublicKey) string {
+	mustBeValidRegion(region)
+
+	keyPairID := randomID("key-")
+	withCloud(func(c *cloud) {
+		r := c.region(region)
+		if _, ok := r.KeyPairs[keyName]; ok {
+			panic(errors.Errorf("simulate, import key pair, key pair already exists; key-name=%s", keyName))
+		}
+
+		r.KeyPairs[keyName] = &KeyPair{
+			ID:        keyPairID,
+			Name:      keyName,
+			PublicKey: string(ssh.MarshalAuthorizedKey(pubKey)),
+			CreatedAt: time.Now(),
+		}
+	})
+
+	return keyPairID
+}
+
+func CreateSecurityGroup(region string, securityGroupName string, sshIngress bool) string {
+	mustBeValidRegion(region)
+
+	securityGroupID := randomID("sg-")
+	withCloud(func(c *cloud) {
+		r := c.region(region)
+
+		// same as AWS provider, group left by an earlier run is taken over and SSH ingress is added to it
+		if sg, ok := r.SecurityGroups[securityGroupName]; ok {
+			securityGroupID = sg.ID
+			sg.SSHIngress = sg.SSHIngress || sshIngress
+			return
+		}
+
+		r.SecurityGroups[securityGroupName] = &SecurityGroup{
+			ID:         securityGroupID,
+			Name:       securityGroupName,
+			SSHIngress: sshIngress,
+			CreatedAt:  time.Now(),
+		}
+	})
+
+	return securityGroupID
+}
+
+func RunInstance(region string, instanceType string, ami string, vpnNodeName string, securityGroupID string, userData string) Instance {
+	mustBeValidRegion(region)
+	if !contains(instanceTypes, instanceType) {
+		panic(tscerr.Errorf(tscerr.BadInput, "simulate, run instance, invalid instance type; instance-type=%s", instanceType))
+	}
+	if !contains(amis, ami) {
+		panic(tscerr.Errorf(tscerr.NotFound, "simulate, run instance, ami does not exist; ami=%s", ami))
+	}
+
+	var inst Instance
+	withCloud(func(c *cloud) {
+		r := c.region(region)
+		if _, ok := r.KeyPairs[vpnNodeName]; !ok {
+			panic(errors.Errorf("simulate, run instance, key pair does not exist; key-name=%s", vpnNodeName))
+		}
+		if r.securityGroupByID(securityGroupID) == nil {
+			panic(errors.Errorf("simulate, run instance, security group does not exist; group-id=%s", securityGroupID))
+		}
+
+		inst = Instance{
+			ID:               randomID("i-"),
+			Name:             vpnNodeName,
+			InstanceType:     instanceType,
+			AMI:              ami,
+			KeyName:          vpnNodeName,
+			SecurityGroupID:  securityGroupID,
+			AvailabilityZone: region + "a",
+			SubnetID:         "subnet-" + region,
+			PrivateIP:        fmt.Sprintf("172.31.%d.%d", len(r.Instances)/250, len(r.Instances)%250+4),
+			// cloud.json is not a secret store, user data carries tailscale auth key
+			UserData:   redact.String(userData),
+			State:      instanceStatePending,
+			LaunchedAt: time.Now(),
+		}
+		r.Instances[inst.ID] = &inst
+	})
+
+	return inst
+}
+
+func WaitForInstanceToInitialize(ctx context.Context, region string, instanceID string) {
+	startTime := time.Now()
+	interrupt.Sleep(ctx, time.Second)
+
+	withCloud(func(c *cloud) {
+		inst := c.region(region).boot(instanceID)
+		if inst.State != instanceStateRunning {
+			panic(errors.New("simulate, wait for instance to initialize, lost instance"))
+		}
+	})
+
+	tsclog.Infof("Instance ready %s", time.Since(startTime))
+}
+
+// ConsoleOutput pretends that cloud-init ran bootstrap from user data successfully, commands are not executed
+func ConsoleOutput(region string, instanceID string) string {
+	var inst Instance
+	withCloud(func(c *cloud) {
+		inst = *c.region(region).boot(instanceID)
+	})
+
+	if inst.State != instanceStateRunning {
+		return ""
+	}
+
+	output := fmt.Sprintf("[    0.000000] Linux version 5.15.0-1011-aws (simulated instance %s)\n", inst.ID)
+	if inst.UserData != "" {
+		output += "Cloud-init v. 22.2 running 'modules:final'\n" + cloudinit.MarkerOK + "\n"
+	}
+
+	return output
+}
+
+func DescribeInstance(region string, instanceID string) Instance {
+	inst, ok := readCloud().region(region).Instances[instanceID]
+	if !ok {
+		panic(tscerr.Errorf(tscerr.NotFound, "simulate, describe instance, instance does not exist; instance-id=%s", instanceID))
+	}
+
+	return *inst
+}
+
+// SSHAddress starts embedded SSH server (if it is not already running), server accepts connections for every instance
+func SSHAddress() string {
+	return serveSSH()
+}
+
+func FindInstance(region string, vpnNodeName string) (Instance, bool) {
+	inst := readCloud().region(region).instanceByName(vpnNodeName)
+	if inst == nil {
+		return Instance{}, false
+	}
+	return *inst, true
+}
+
+func FindSecurityGroup(region string, securityGroupName string) (string, bool) {
+	sg, ok := readCloud().region(region).SecurityGroups[securityGroupName]
+	if !ok {
+		return "", false
+	}
+	return sg.ID, true
+}
+
+func FindKeyPair(region string, keyName string) (string, bool) {
+	kp, ok := readCloud().region(region).KeyPairs[keyName]
+	if !ok {
+		return "", false
+	}
+	return kp.ID, true
+}
+
+// Managed functions list every resource of the region, all simulated resources are created by tscalectl
+
+func ManagedInstances(region string) []Instance {
+	mustBeValidRegion(region)
+
+	var instances []Instance
+	for _, inst := range readCloud().region(region).Instances {
+		instances = append(instances, *inst)
+	}
+	return instances
+}
+
+func ManagedSecurityGroups(region string) []SecurityGroup {
+	mustBeValidRegion(region)
+
+	var securityGroups []SecurityGroup
+	for _, sg := range readCloud().region(region).SecurityGroups {
+		securityGroups = append(securityGroups, *sg)
+	}
+	return securityGroups
+}
+
+func ManagedKeyPairs(region string) []KeyPair {
+	mustBeValidRegion(region)
+
+	var keyPairs []KeyPair
+	for _, kp := range readCloud().region(region).KeyPairs {
+		keyPairs = append(keyPairs, *kp)
+	}
+	return keyPairs
+}
+
+func TerminateInstance(region string, instanceID string) {
+	withCloud(func(c *cloud) {
+		// same as in AWS, terminating instance which does not exist anymore is not an error for tscalectl
+		if inst, ok := c.region(region).Instances[instanceID]; ok {
+			inst.State = instanceStateTerminated
+			inst.PublicIP = ""
+		}
+	})
+}
+
+func WaitForInstanceToTerminate(region string, instanceID string) {
+	if inst, ok := readCloud().region(region).Instances[instanceID]; ok && inst.State != instanceStateTerminated {
+		panic(errors.Errorf("simulate, wait for instance to terminate, instance still alive; state=%s", inst.State))
+	}
+	tsclog.Infof("Instance state: %s", instanceStateTerminated)
+}
+
+func DeleteSecurityGroup(region string, securityGroupID string) {
+	withCloud(func(c *cloud) {
+		r := c.region(region)
+		sg := r.securityGroupByID(securityGroupID)
+		if sg == nil {
+			return
+		}
+
+		for _, inst := range r.Instances {
+			if inst.SecurityGroupID == sg.ID && inst.State != instanceStateTerminated {
+				panic(errors.Errorf("simulate, delete security group, security group is used by instance; instance-id=%s", inst.ID))
+			}
+		}
+
+		delete(r.SecurityGroups, sg.Name)
+	})
+}
+
+func DeleteKeyPair(region string, keyPairID string) {
+	withCloud(func(c *cloud) {
+		r := c.region(region)
+		for name, kp := range r.KeyPairs {
+			if kp.ID == keyPairID {
+				delete(r.KeyPairs, name)
+			}
+		}
+	})
+}
+
+func (c *cloud) region(name string) *region {
+	r, ok := c.Regions[name]
+	if !ok {
+		r = &region{}
+		c.Regions[name] = r
+	}
+
+	// maps are omitted from JSON when region was stored without some resource type
+	if r.KeyPairs == nil {
+		r.KeyPairs = make(map[string]*KeyPair)
+	}
+	if r.SecurityGroups == nil {
+		r.SecurityGroups = make(map[string]*SecurityGroup)
+	}
+	if r.Instances == nil {
+		r.Instances = make(map[string]*Instance)
+	}
+
+	return r
+}
+
+// instanceByName returns Instance which is not terminated, AWS keeps terminated instances visible for some time
+// and tscalectl reuses names, so there can be more instances with the same name
+func (r *region) instanceByName(name string) *Instance {
+	for _, inst := range r.Instances {
+		if inst.Name == name && inst.State != instanceStateTerminated {
+			return inst
+		}
+	}
+	return nil
+}
+
+// boot moves instance to running state once its boot time passed, instance boots on its own, even if nobody waits for it
+func (r *region) boot(instanceID string) *Instance {
+	inst, ok := r.Instances[instanceID]
+	if !ok {
+		panic(tscerr.Errorf(tscerr.NotFound, "simulate, instance does not exist; instance-id=%s", instanceID))
+	}
+
+	if inst.State == instanceStatePending && time.Since(inst.LaunchedAt) >= time.Second {
+		inst.State = instanceStateRunning
+		inst.PublicIP = "127.0.0.1"
+	}
+
+	return inst
+}
+
+func (r *region) securityGroupByID(securityGroupID string) *SecurityGroup {
+	for _, sg := range r.SecurityGroups {
+		if sg.ID == securityGroupID {
+			return sg
+		}
+	}
+	return nil
+}
+
+func withCloud(fn func(c *cloud)) {
+	fileutil.MkdirAll(tscos.SimulateDir())
+
+	unlock := fileutil.Lock(tscos.SimulateCloudLockFile())
+	defer unlock()
+
+	c := getCloud()
+	fn(c)
+	storeCloud(c)
+}
+
+func readCloud() *cloud {
+	fileutil.MkdirAll(tscos.SimulateDir())
+
+	unlock := fileutil.Lock(tscos.SimulateCloudLockFile())
+	defer unlock()
+
+	return getCloud()
+}
+
+func getCloud() *cloud {
+	if _, err := os.Stat(tscos.SimulateCloudFile()); os.IsNotExist(err) {
+		return &cloud{Regions: make(map[string]*region)}
+	} else if err != nil {
+		panic(errors.Wrap(err, "simulate, get cloud, os stat"))
+	}
+
+	b := fileutil.ReadFile(tscos.SimulateCloudFile())
+	if len(b) == 0 {
+		return &cloud{Regions: make(map[string]*region)}
+	}
+
+	var c cloud
+	err := json.Unmarshal(b, &c)
+	if err != nil {
+		panic(errors.Wrap(err, "simulate, get cloud, json unmarshal"))
+	}
+	if c.Regions == nil {
+		c.Regions = make(map[string]*region)
+	}
+
+	return &c
+}
+
+func storeCloud(c *cloud) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(errors.Wrap(err, "simulate, store cloud, json marshal"))
+	}
+
+	fileutil.WriteFile(tscos.SimulateCloudFile(), b)
+}
+
+func mustBeValidRegion(region string) {
+	if !contains(regions, region) {
+		panic(tscerr.Errorf(tscerr.BadInput, "simulate, invalid region; region=%s, allowed-regions=%+v", region, regions))
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func randomID(prefix string) string {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		panic(errors.Wrap(err, "simulate, random id"))
+	}
+	return prefix + hex.EncodeToString(b)[:17]
+}
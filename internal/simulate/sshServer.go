@@ -0,0 +1,208 @@
+package simulate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// Embedded SSH server plays the role of every simulated instance. It lives only as long as the tscalectl process
+// which started it, which is enough for bootstrapping node in the up command.
+
+var sshAddr string
+var sshOnce = &sync.Once{}
+
+func serveSSH() string {
+	sshOnce.Do(func() {
+		config := &ssh.ServerConfig{
+			PublicKeyCallback: authorize,
+		}
+		config.AddHostKey(hostKey())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(errors.Wrap(err, "simulate, serve ssh, listen"))
+		}
+		sshAddr = listener.Addr().String()
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go handleConn(conn, config)
+			}
+		}()
+	})
+
+	return sshAddr
+}
+
+func hostKey() ssh.Signer {
+	fileutil.MkdirAll(tscos.SimulateDir())
+
+	unlock := fileutil.Lock(tscos.SimulateHostKeyLockFile())
+	defer unlock()
+
+	// host key is persisted, so it stays the same between tscalectl processes
+	if _, err := os.Stat(tscos.SimulateHostKeyFile()); err == nil {
+		b := fileutil.ReadFile(tscos.SimulateHostKeyFile())
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			panic(errors.Wrap(err, "simulate, host key, parse private key"))
+		}
+		return signer
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(errors.Wrap(err, "simulate, host key, generate key"))
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		panic(errors.Wrap(err, "simulate, host key, marshal private key"))
+	}
+	fileutil.WriteFilePerm(tscos.SimulateHostKeyFile(), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		panic(errors.Wrap(err, "simulate, host key, new signer from key"))
+	}
+	return signer
+}
+
+// authorize accepts keys of imported key pairs which belong to live instances
+func authorize(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if conn.User() != "ubuntu" {
+		return nil, errors.Errorf("unknown user %s", conn.User())
+	}
+
+	for _, r := range readCloud().Regions {
+		for _, inst := range r.Instances {
+			if inst.State == instanceStateTerminated {
+				continue
+			}
+
+			// same as in AWS, instance without SSH ingress rule is not reachable
+			if sg := r.securityGroupByID(inst.SecurityGroupID); sg == nil || !sg.SSHIngress {
+				continue
+			}
+
+			kp, ok := r.KeyPairs[inst.KeyName]
+			if !ok {
+				continue
+			}
+
+			authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(kp.PublicKey))
+			if err != nil {
+				continue
+			}
+
+			if bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+				return &ssh.Permissions{Extensions: map[string]string{"instance-id": inst.ID}}, nil
+			}
+		}
+	}
+
+	return nil, errors.New("public key is not authorized for any simulated instance")
+}
+
+func handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go handleSession(channel, requests, serverConn.Permissions.Extensions["instance-id"])
+	}
+}
+
+func handleSession(channel ssh.Channel, requests <-chan *ssh.Request, instanceID string) {
+	defer channel.Close()
+
+	pty := false
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			// command reads its input (e.g. tailscale auth key) till EOF, input itself is not kept, terminal input
+			// never ends
+			var n int64
+			if !pty {
+				n, _ = io.Copy(io.Discard, channel)
+			}
+
+			recordCommand(instanceID, payload.Command)
+			if n > 0 {
+				fmt.Fprintf(channel, "simulated: %s (stdin: %d bytes)\n", payload.Command, n)
+			} else {
+				fmt.Fprintf(channel, "simulated: %s\n", payload.Command)
+			}
+			exit(channel, 0)
+			return
+		case "shell":
+			req.Reply(true, nil)
+
+			fmt.Fprintf(channel, "tscalectl simulated instance %s, interactive shell is not supported\r\n", instanceID)
+			exit(channel, 0)
+			return
+		case "pty-req":
+			pty = true
+			req.Reply(true, nil)
+		case "env":
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func recordCommand(instanceID string, command string) {
+	withCloud(func(c *cloud) {
+		for _, r := range c.Regions {
+			if inst, ok := r.Instances[instanceID]; ok {
+				inst.Commands = append(inst.Commands, command)
+			}
+		}
+	})
+}
+
+func exit(channel ssh.Channel, status uint32) {
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
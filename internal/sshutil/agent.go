@@ -0,0 +1,54 @@
+package sshutil
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// AddToAgent adds private key to ssh-agent of SSH_AUTH_SOCK, agent forgets it after lifetime. Encrypted key is opened
+// with passphrase of credentials file, decrypted key is never written to disk. Returned public key file makes
+// `ssh -i` pick the key from the agent.
+func AddToAgent(keyName string, lifetime time.Duration) string {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		panic(tscerr.New(tscerr.BadInput, "ssh agent, SSH_AUTH_SOCK is not set, start the agent with eval \"$(ssh-agent)\""))
+	}
+
+	privateKey := LoadPrivateKey(keyName)
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		panic(errors.Wrapf(err, "ssh agent, dial; socket=%s", sock))
+	}
+	defer conn.Close()
+
+	err = agent.NewClient(conn).Add(agent.AddedKey{
+		PrivateKey:   privateKey,
+		Comment:      keyName,
+		LifetimeSecs: uint32(lifetime.Seconds()),
+	})
+	if err != nil {
+		panic(errors.Wrapf(err, "ssh agent, add key; key=%s", keyName))
+	}
+
+	// nodes imported with their private key may miss the public one
+	pubFile := tscos.AwsKeyPairsDir() + "/" + keyName + ".pub"
+	if _, err = os.Stat(pubFile); os.IsNotExist(err) {
+		pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
+		if err != nil {
+			panic(errors.Wrap(err, "ssh agent, public ssh key from rsa public key"))
+		}
+		fileutil.WriteFile(pubFile, ssh.MarshalAuthorizedKey(pub))
+	}
+
+	return pubFile
+}
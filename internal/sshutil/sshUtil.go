@@ -2,35 +2,65 @@ package sshutil
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
 	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/retry"
+	"github.com/svennjegac/tailscale.node-provider/internal/seal"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
 	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
 )
 
-func StartTailscale(privateKey *rsa.PrivateKey, ec2PublicIP string, tailscaleAuthKey string, hostname string, exitNode bool) {
-	// ssh config
+func InstallTailscale(ctx context.Context, privateKey *rsa.PrivateKey, host string) {
+	client := dial(ctx, privateKey, host)
+	defer client.Close()
+
+	execSSH(ctx, client, "curl -fsSL https://tailscale.com/install.sh | sh")
+	execSSH(ctx, client, "echo 'net.ipv4.ip_forward = 1' | sudo tee -a /etc/sysctl.conf")
+	execSSH(ctx, client, "echo 'net.ipv6.conf.all.forwarding = 1' | sudo tee -a /etc/sysctl.conf")
+	execSSH(ctx, client, "sudo sysctl -p /etc/sysctl.conf")
+}
+
+func TailscaleUp(ctx context.Context, privateKey *rsa.PrivateKey, host string, tailscaleAuthKey string, hostname string, exitNode bool) {
+	client := dial(ctx, privateKey, host)
+	defer client.Close()
+
+	advertiseExitNodeFlag := "--advertise-exit-node"
+	if !exitNode {
+		advertiseExitNodeFlag = ""
+	}
+
+	// auth key goes through stdin, on command line it would be seen by ps and logged by sudo
+	execSSHInput(ctx, client, fmt.Sprintf("sudo tailscale up --auth-key file:/dev/stdin --hostname %s %s", hostname, advertiseExitNodeFlag), tailscaleAuthKey)
+}
+
+// dial connects to host which is already in known hosts (see UpdateKnownHosts)
+func dial(ctx context.Context, privateKey *rsa.PrivateKey, host string) *ssh.Client {
 	hostKeyCallback, err := knownhosts.New(tscos.KnownHostsFile())
 	if err != nil {
-		panic(errors.Wrap(err, "start tailscale, host key callback"))
+		panic(errors.Wrap(err, "ssh dial, host key callback"))
 	}
 
 	signer, err := ssh.NewSignerFromKey(privateKey)
 	if err != nil {
-		panic(errors.Wrap(err, "start tailscale, new signer from key"))
+		panic(errors.Wrap(err, "ssh dial, new signer from key"))
 	}
 
 	config := &ssh.ClientConfig{
@@ -39,41 +69,68 @@ func StartTailscale(privateKey *rsa.PrivateKey, ec2PublicIP string, tailscaleAut
 			ssh.PublicKeys(signer),
 		},
 		HostKeyCallback: hostKeyCallback,
-		Timeout:         time.Second * 10,
+		Timeout:         retry.SSHDialTimeout,
 	}
 
-	// connect to ssh server
-	client, err := ssh.Dial("tcp", ec2PublicIP+":22", config)
+	// connect to ssh server, it may still be starting, or network may drop the first packets
+	var client *ssh.Client
+	err = retry.Do(ctx, retry.SSHDial(), dialRetryable, func(ctx context.Context) (err error) {
+		client, err = dialContext(ctx, Addr(host), config)
+		return err
+	})
 	if err != nil {
-		panic(errors.Wrap(err, "start tailscale, ssh dial"))
+		interrupt.Check(ctx)
+		panic(tscerr.Wrap(dialErrorKind(err), err, "ssh dial"))
 	}
-	defer client.Close()
 
-	advertiseExitNodeFlag := "--advertise-exit-node"
-	if !exitNode {
-		advertiseExitNodeFlag = ""
-	}
+	return client
+}
 
-	execSSH(client, "curl -fsSL https://tailscale.com/install.sh | sh")
-	execSSH(client, "echo 'net.ipv4.ip_forward = 1' | sudo tee -a /etc/sysctl.conf")
-	execSSH(client, "echo 'net.ipv6.conf.all.forwarding = 1' | sudo tee -a /etc/sysctl.conf")
-	execSSH(client, "sudo sysctl -p /etc/sysctl.conf")
-	execSSH(client, fmt.Sprintf("sudo tailscale up --auth-key %s --hostname %s %s", tailscaleAuthKey, hostname, advertiseExitNodeFlag))
+// execSSH runs command on client, command which does not finish within SSHCommandTimeout is stopped (the connection
+// is closed)
+func execSSH(ctx context.Context, client *ssh.Client, command string) {
+	execSSHInput(ctx, client, command, "")
 }
 
-func execSSH(client *ssh.Client, command string) {
+// execSSHInput is execSSH which writes stdin to command's standard input
+func execSSHInput(ctx context.Context, client *ssh.Client, command string, stdin string) {
 	session, err := client.NewSession()
 	if err != nil {
 		panic(errors.Wrap(err, "ssh client new session"))
 	}
 	defer session.Close()
 
-	var buff bytes.Buffer
-	session.Stdout = &buff
-	if err = session.Run(command); err != nil {
+	cmdCtx := ctx
+	if retry.SSHCommandTimeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, retry.SSHCommandTimeout)
+		defer cancel()
+	}
+
+	stop := closeOnDone(cmdCtx, client)
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	tsclog.Debugf("Running on %s: %s", host, command)
+
+	// remote output is logged line by line while command runs
+	stdout := tsclog.RemoteWriter(host, "stdout")
+	stderr := tsclog.RemoteWriter(host, "stderr")
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if stdin != "" {
+		session.Stdin = strings.NewReader(stdin)
+	}
+	err = session.Run(command)
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		interrupt.Check(ctx)
+		if cmdCtx.Err() != nil {
+			panic(tscerr.Wrapf(tscerr.Timeout, err, "ssh session run command, command did not finish within %s; command:%s", retry.SSHCommandTimeout, command))
+		}
 		panic(errors.Wrap(err, "ssh session run command; command:"+command))
 	}
-	fmt.Println(buff.String())
 }
 
 func CreateKeyPair(keyName string) (*rsa.PrivateKey, ssh.PublicKey) {
@@ -82,26 +139,23 @@ func CreateKeyPair(keyName string) (*rsa.PrivateKey, ssh.PublicKey) {
 		panic(errors.Wrap(err, "generate private RSA key"))
 	}
 
+	// passphrase may be asked for, key pair is not locked meanwhile
+	sealKey, sealed := creds.SSHKeysKey()
+
 	fileutil.MkdirAll(tscos.AwsKeyPairsDir())
 
-	unlockPem := fileutil.Lock(tscos.AwsKeyPairsDir() + "/" + keyName + ".pem")
-	defer unlockPem()
+	unlock := fileutil.Lock(tscos.KeyPairLockFile(keyName))
+	defer unlock()
 
 	// generate and write private key as PEM
-	privateKeyFile, err := os.Create(tscos.AwsKeyPairsDir() + "/" + keyName + ".pem")
-	if err != nil {
-		panic(errors.Wrap(err, "create RSA pem file"))
-	}
-	defer privateKeyFile.Close()
-
-	privateKeyPEM := &pem.Block{
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
 		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	if sealed {
+		privateKeyPEM = sealKey.Seal(privateKeyPEM)
 	}
-	if err = pem.Encode(privateKeyFile, privateKeyPEM); err != nil {
-		panic(errors.Wrap(err, "encode RSA to pem file"))
-	}
-	privateKeyFile.Chmod(0400)
+	fileutil.WriteFilePerm(privateKeyFile(keyName), privateKeyPEM, 0400)
 
 	// generate and write public key
 	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
@@ -109,33 +163,108 @@ func CreateKeyPair(keyName string) (*rsa.PrivateKey, ssh.PublicKey) {
 		panic(errors.Wrap(err, "public ssh key from rsa public key"))
 	}
 
-	unlockPub := fileutil.Lock(tscos.AwsKeyPairsDir() + "/" + keyName + ".pub")
-	defer unlockPub()
-
-	fileutil.WriteFilePerm(tscos.AwsKeyPairsDir()+"/"+keyName+".pub", ssh.MarshalAuthorizedKey(pub), 0644)
+	fileutil.WriteFile(tscos.AwsKeyPairsDir()+"/"+keyName+".pub", ssh.MarshalAuthorizedKey(pub))
 
 	return privateKey, pub
 }
 
+func LoadPrivateKey(keyName string) *rsa.PrivateKey {
+	unlock := fileutil.Lock(tscos.KeyPairLockFile(keyName))
+	defer unlock()
+
+	if !HasPrivateKey(keyName) {
+		panic(tscerr.Errorf(tscerr.NotFound, "load private key, private key does not exist; key=%s", keyName))
+	}
+
+	b := fileutil.ReadFile(privateKeyFile(keyName))
+	if seal.IsSealed(b) {
+		b = creds.Open(b, keyName+".pem")
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		panic(tscerr.New(tscerr.BadInput, "load private key, no PEM data found"))
+	}
+
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		panic(errors.Wrap(err, "load private key, parse PKCS1 private key"))
+	}
+
+	return privateKey
+}
+
+// IsPrivateKeySealed reports whether private key of the key pair is encrypted, ssh can't use it then
+func IsPrivateKeySealed(keyName string) bool {
+	b, err := os.ReadFile(privateKeyFile(keyName))
+	return err == nil && seal.IsSealed(b)
+}
+
+// SealPrivateKeys encrypts private keys of current context with key, nil key decrypts them. Keys which are already
+// encrypted are opened with passphrase of credentials file. Number of changed keys is returned.
+func SealPrivateKeys(key *seal.Key) int {
+	files, err := filepath.Glob(tscos.AwsKeyPairsDir() + "/*.pem")
+	if err != nil {
+		panic(errors.Wrap(err, "seal private keys, glob"))
+	}
+
+	changed := 0
+	for _, f := range files {
+		keyName := strings.TrimSuffix(filepath.Base(f), ".pem")
+		if sealPrivateKey(keyName, key) {
+			changed++
+		}
+	}
+	return changed
+}
+
+func sealPrivateKey(keyName string, key *seal.Key) bool {
+	unlock := fileutil.Lock(tscos.KeyPairLockFile(keyName))
+	defer unlock()
+
+	b := fileutil.ReadFile(privateKeyFile(keyName))
+	wasSealed := seal.IsSealed(b)
+	if !wasSealed && key == nil {
+		return false
+	}
+
+	if wasSealed {
+		b = creds.Open(b, keyName+".pem")
+	}
+	if key != nil {
+		b = key.Seal(b)
+	}
+	fileutil.WriteFilePerm(privateKeyFile(keyName), b, 0400)
+
+	return true
+}
+
+func privateKeyFile(keyName string) string {
+	return tscos.AwsKeyPairsDir() + "/" + keyName + ".pem"
+}
+
+// HasPrivateKey reports whether private key of the key pair exists locally
+func HasPrivateKey(keyName string) bool {
+	_, err := os.Stat(tscos.AwsKeyPairsDir() + "/" + keyName + ".pem")
+	return err == nil
+}
+
 func DeleteKeyPair(keyName string) {
 	fileutil.MkdirAll(tscos.AwsKeyPairsDir())
 
-	unlockPem := fileutil.Lock(tscos.AwsKeyPairsDir() + "/" + keyName + ".pem")
-	defer unlockPem()
+	unlock := fileutil.Lock(tscos.KeyPairLockFile(keyName))
+	defer unlock()
 
 	if _, err := os.Stat(tscos.AwsKeyPairsDir() + "/" + keyName + ".pem"); err == nil {
 		fileutil.Remove(tscos.AwsKeyPairsDir() + "/" + keyName + ".pem")
 	}
 
-	unlockPub := fileutil.Lock(tscos.AwsKeyPairsDir() + "/" + keyName + ".pub")
-	defer unlockPub()
-
 	if _, err := os.Stat(tscos.AwsKeyPairsDir() + "/" + keyName + ".pub"); err == nil {
 		fileutil.Remove(tscos.AwsKeyPairsDir() + "/" + keyName + ".pub")
 	}
 }
 
-func UpdateKnownHosts(privKey *rsa.PrivateKey, ec2InstancePublicIP string) {
+func UpdateKnownHosts(ctx context.Context, privKey *rsa.PrivateKey, host string) {
 	signer, err := ssh.NewSignerFromKey(privKey)
 	if err != nil {
 		panic(errors.Wrap(err, "update known hosts, new signer from key"))
@@ -147,13 +276,21 @@ func UpdateKnownHosts(privKey *rsa.PrivateKey, ec2InstancePublicIP string) {
 			ssh.PublicKeys(signer),
 		},
 		HostKeyCallback: keyPrint,
-		Timeout:         time.Second * 10,
+		Timeout:         retry.SSHDialTimeout,
 	}
 
-	// connect ot ssh server
-	client, err := ssh.Dial("tcp", ec2InstancePublicIP+":22", config)
+	// connect to ssh server, it is retried while instance boots (sshd starts before cloud-init installs the key,
+	// so authentication may fail for a while too)
+	var client *ssh.Client
+	err = retry.Do(ctx, retry.SSHDial(), func(err error) bool {
+		return dialRetryable(err) || strings.Contains(err.Error(), "unable to authenticate")
+	}, func(ctx context.Context) (err error) {
+		client, err = dialContext(ctx, Addr(host), config)
+		return err
+	})
 	if err != nil {
-		panic(errors.Wrap(err, "update known hosts, dial ssh"))
+		interrupt.Check(ctx)
+		panic(tscerr.Wrap(dialErrorKind(err), err, "update known hosts, dial ssh"))
 	}
 	defer client.Close()
 }
@@ -161,7 +298,7 @@ func UpdateKnownHosts(privKey *rsa.PrivateKey, ec2InstancePublicIP string) {
 func keyPrint(dialAddr string, addr net.Addr, key ssh.PublicKey) error {
 	fileutil.MkdirAllFromFile(tscos.KnownHostsFile())
 
-	unlock := fileutil.Lock(tscos.KnownHostsFile())
+	unlock := fileutil.Lock(tscos.KnownHostsLockFile())
 	defer unlock()
 
 	f, err := os.OpenFile(tscos.KnownHostsFile(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
@@ -170,15 +307,90 @@ func keyPrint(dialAddr string, addr net.Addr, key ssh.PublicKey) error {
 	}
 	defer f.Close()
 
-	// if known hosts didn't exist before, fileutil created it with wrong perm
+	// known hosts created by older tscalectl versions have wrong perm
 	err = f.Chmod(0600)
 	if err != nil {
 		panic(errors.Wrap(err, "create known hosts, chmod"))
 	}
 
-	if _, err = f.WriteString(fmt.Sprintf("%s %s %s\n", strings.Split(dialAddr, ":")[0], key.Type(), base64.StdEncoding.EncodeToString(key.Marshal()))); err != nil {
+	// retried dials present the same key again
+	line := knownhosts.Line([]string{knownhosts.Normalize(dialAddr)}, key)
+	if b, err := os.ReadFile(tscos.KnownHostsFile()); err == nil && bytes.Contains(b, []byte(line+"\n")) {
+		return nil
+	}
+
+	if _, err = f.WriteString(line + "\n"); err != nil {
 		return errors.Wrap(err, "write to known hosts file")
 	}
 
 	return nil
 }
+
+// Addr returns SSH address of a host, host can be provided with or without port (default port is 22)
+func Addr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "22")
+}
+
+// dialContext is ssh.Dial which gives up when ctx is canceled
+func dialContext(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	d := net.Dialer{Timeout: config.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// handshake does not know about ctx, closed connection stops it
+	stop := closeOnDone(ctx, conn)
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	stop()
+	if err != nil {
+		conn.Close()
+		// handshake failed because connection was closed
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), err.Error())
+		}
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// closeOnDone closes c when ctx is canceled, until returned func is called
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// dialErrorKind tells whether SSH connection failed because of host key or authentication, or because host did not answer
+func dialErrorKind(err error) tscerr.Kind {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		return tscerr.Timeout
+	}
+	// ssh handshake flattens knownhosts.KeyError and auth errors into its message
+	msg := err.Error()
+	if strings.Contains(msg, "knownhosts:") || strings.Contains(msg, "unable to authenticate") {
+		return tscerr.Auth
+	}
+	return tscerr.Unknown
+}
+
+// dialRetryable tells whether SSH server may accept connection later, e.g. it is still starting while instance boots
+func dialRetryable(err error) bool {
+	if retry.Transient(err) {
+		return true
+	}
+	// ssh handshake flattens errors into its message
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "connection reset") || strings.HasSuffix(msg, "EOF")
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// ErrVersionConflict means state was changed by someone else since it was read
+var ErrVersionConflict = errors.New("state was changed by another tscalectl, retry the command")
+
+// Backend stores the state document. Every read-modify-write cycle runs under Lock, and Write additionally checks
+// that stored document still has the version which was read (optimistic concurrency), so a writer which lost its
+// lock (e.g. expired lease) can't overwrite changes of others.
+type Backend interface {
+	// Lock locks the state, returned func unlocks it
+	Lock(ctx context.Context) func()
+	// Holder returns holder of the state lock, ok is false if state is not locked
+	Holder(ctx context.Context) (holder fileutil.LockHolder, ok bool)
+	// ForceUnlock clears the state lock held by someone else
+	ForceUnlock(ctx context.Context)
+	// Read returns state document and its version, empty document means there is no state yet
+	Read(ctx context.Context) (b []byte, version string)
+	// Write stores the document if stored version still equals version, it returns the new version
+	Write(ctx context.Context, b []byte, version string) string
+}
+
+var backend Backend = fileBackend{}
+
+// SetBackend replaces the default file backend, it is called before any state function
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// fileBackend keeps state in the local state file
+type fileBackend struct{}
+
+func NewFileBackend() Backend {
+	return fileBackend{}
+}
+
+func (fileBackend) Lock(ctx context.Context) func() {
+	fileutil.MkdirAll(tscos.ContextDir())
+	return fileutil.LockContext(ctx, tscos.StateLockFile())
+}
+
+func (fileBackend) Holder(ctx context.Context) (fileutil.LockHolder, bool) {
+	return fileutil.Holder(tscos.StateLockFile())
+}
+
+func (fileBackend) ForceUnlock(ctx context.Context) {
+	fileutil.ForceUnlock(tscos.StateLockFile())
+}
+
+func (fileBackend) Read(ctx context.Context) ([]byte, string) {
+	b := readStateFile()
+	if len(b) == 0 {
+		return nil, ""
+	}
+
+	if _, err := parseState(b); err != nil {
+		b = recoverState(b, err)
+	}
+
+	return b, contentVersion(b)
+}
+
+func (fileBackend) Write(ctx context.Context, b []byte, version string) string {
+	if contentVersion(readStateFile()) != version {
+		panic(errors.Wrap(ErrVersionConflict, "write state file"))
+	}
+
+	writeStateFile(b, invocationOf(ctx))
+
+	return contentVersion(b)
+}
+
+func readStateFile() []byte {
+	if _, err := os.Stat(tscos.StateFile()); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		panic(errors.Wrap(err, "get state, os stat"))
+	}
+
+	return fileutil.ReadFile(tscos.StateFile())
+}
+
+// ContextState reads local state file of the named context without locking it, e.g. to find resources which another
+// context (possibly in the same AWS account) owns. Missing file is an empty state.
+func ContextState(name string) *State {
+	file := tscos.NamedStateFile(name)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return &State{SchemaVersion: CurrentSchemaVersion, Nodes: make(map[int]*VPNNode)}
+	} else if err != nil {
+		panic(errors.Wrapf(err, "context state, os stat; context=%s", name))
+	}
+
+	s, err := parseState(fileutil.ReadFile(file))
+	if err != nil {
+		panic(errors.Wrapf(err, "context state, state file is corrupt; context=%s, state-file=%s", name, file))
+	}
+	if s.Nodes == nil {
+		s.Nodes = make(map[int]*VPNNode)
+	}
+	return s
+}
+
+// contentVersion of the file backend is the hash of the document, so it needs no extra bookkeeping
+func contentVersion(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
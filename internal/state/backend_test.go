@@ -0,0 +1,67 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+func TestFileBackendVersionConflict(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+
+	b := NewFileBackend()
+	unlock := b.Lock(ctx)
+	defer unlock()
+
+	doc, version := b.Read(ctx)
+	if doc != nil || version != "" {
+		t.Fatalf("Read() of missing file = %q, %q, want empty", doc, version)
+	}
+
+	v1 := b.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "")
+	if _, got := b.Read(ctx); got != v1 {
+		t.Fatalf("Read() version = %q, want %q", got, v1)
+	}
+	v2 := b.Write(ctx, []byte(`{"schema_version":1,"nodes":{},"last_id":1}`), v1)
+	if v2 == v1 {
+		t.Fatalf("Write() of changed document kept version %q", v1)
+	}
+
+	// writer which read the first version lost the race
+	err := catch(func() { b.Write(ctx, []byte(`{"schema_version":1,"nodes":{},"last_id":2}`), v1) })
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Write() with stale version error = %v, want %v", err, ErrVersionConflict)
+	}
+}
+
+func TestContextState(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+
+	if s := ContextState("other"); len(s.Nodes) != 0 {
+		t.Fatalf("ContextState() of context without state has %d nodes", len(s.Nodes))
+	}
+
+	tscos.Context = "other"
+	AddNewNode(ctx, &VPNNode{Provider: "simulate", Region: "eu-north-1", InstanceType: "t3.small"})
+	tscos.Context = tscos.DefaultContext
+
+	s := ContextState("other")
+	if len(s.Nodes) != 1 || s.Nodes[0].TscalectlName != "000-eu-north-1-t3.small" {
+		t.Fatalf("ContextState() nodes = %+v, want node of other context", s.Nodes)
+	}
+	if len(GetState(ctx).Nodes) != 0 {
+		t.Fatalf("GetState() of default context has nodes of other context")
+	}
+
+	fileutil.MkdirAllFromFile(tscos.NamedStateFile("broken"))
+	fileutil.WriteFile(tscos.NamedStateFile("broken"), []byte("{"))
+	if err := catch(func() { ContextState("broken") }); err == nil {
+		t.Fatalf("ContextState() of corrupt state file did not fail")
+	}
+}
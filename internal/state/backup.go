@@ -0,0 +1,186 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// Generations is the number of previous state files kept in the backups directory.
+// Generation 1 is the state before the last command which changed it, generation 2 the one before it, ...
+const Generations = 20
+
+// Invocation identifies this tscalectl process. Generations are rotated once per invocation, so a command which
+// writes state many times (e.g. up stores every finished step) keeps one generation instead of pushing out the others.
+var Invocation = randomLeaseID()
+
+// rotatedBy maps state file to the invocation which rotated its generations last
+var rotatedBy = map[string]string{}
+
+type invocationKey struct{}
+
+// withInvocation makes state written with ctx rotate generations once per the provided invocation (e.g. the one
+// of a remote CLI writing through state serve), empty invocation rotates them on every write
+func withInvocation(ctx context.Context, invocation string) context.Context {
+	return context.WithValue(ctx, invocationKey{}, invocation)
+}
+
+func invocationOf(ctx context.Context) string {
+	if invocation, ok := ctx.Value(invocationKey{}).(string); ok {
+		return invocation
+	}
+	return Invocation
+}
+
+type Generation struct {
+	Generation int
+	SavedAt    time.Time
+	Nodes      int
+	// Err is set if generation can't be used (e.g. it is corrupt itself)
+	Err error
+}
+
+// ListGenerations returns stored generations of the state file, the newest first
+func ListGenerations(ctx context.Context) []Generation {
+	MustUseFileBackend("list state generations")
+
+	fileutil.MkdirAll(tscos.ContextDir())
+
+	unlock := fileutil.LockContext(ctx, tscos.StateLockFile())
+	defer unlock()
+
+	var gens []Generation
+	for g := 1; g <= Generations; g++ {
+		fi, err := os.Stat(generationFile(g))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			panic(errors.Wrap(err, "list state generations, os stat"))
+		}
+
+		gen := Generation{Generation: g, SavedAt: fi.ModTime()}
+		s, err := parseState(fileutil.ReadFile(generationFile(g)))
+		if err != nil {
+			gen.Err = err
+		} else {
+			gen.Nodes = len(s.Nodes)
+		}
+		gens = append(gens, gen)
+	}
+
+	return gens
+}
+
+// Restore replaces state file with the provided generation. Replaced state file becomes generation 1,
+// so restore can be undone by restoring generation 1.
+func Restore(ctx context.Context, generation int) {
+	if generation < 1 || generation > Generations {
+		panic(tscerr.Errorf(tscerr.BadInput, "restore state, invalid generation; generation=%d, allowed-generations=1-%d", generation, Generations))
+	}
+
+	MustUseFileBackend("restore state")
+
+	fileutil.MkdirAll(tscos.ContextDir())
+
+	unlock := fileutil.LockContext(ctx, tscos.StateLockFile())
+	defer unlock()
+
+	if _, err := os.Stat(generationFile(generation)); os.IsNotExist(err) {
+		panic(tscerr.Errorf(tscerr.NotFound, "restore state, generation does not exist; generation=%d", generation))
+	}
+
+	b := fileutil.ReadFile(generationFile(generation))
+	if _, err := parseState(b); err != nil {
+		panic(errors.Wrapf(err, "restore state, generation is corrupt; generation=%d", generation))
+	}
+
+	// replaced state always becomes generation 1, so restore can be undone
+	writeStateFile(b, "")
+}
+
+// writeStateFile rotates generations on the first write of invocation and atomically replaces the state file,
+// callers hold the state lock
+func writeStateFile(b []byte, invocation string) {
+	if invocation == "" || rotatedBy[tscos.StateFile()] != invocation {
+		if current := readStateFile(); len(current) > 0 {
+			if _, err := parseState(current); err == nil {
+				rotateGenerations(current)
+				rotatedBy[tscos.StateFile()] = invocation
+			}
+		}
+	}
+
+	fileutil.WriteFile(tscos.StateFile(), b)
+}
+
+func rotateGenerations(current []byte) {
+	fileutil.MkdirAll(tscos.StateBackupsDir())
+
+	err := os.Remove(generationFile(Generations))
+	if err != nil && !os.IsNotExist(err) {
+		panic(errors.Wrap(err, "rotate state generations, remove oldest"))
+	}
+
+	for g := Generations - 1; g >= 1; g-- {
+		err = os.Rename(generationFile(g), generationFile(g+1))
+		if err != nil && !os.IsNotExist(err) {
+			panic(errors.Wrapf(err, "rotate state generations, rename; generation=%d", g))
+		}
+	}
+
+	fileutil.WriteFile(generationFile(1), current)
+}
+
+// recoverState is called when state file is corrupt. The newest generation which can be parsed replaces it,
+// corrupt file is kept next to it for inspection.
+func recoverState(corrupt []byte, cause error) []byte {
+	for g := 1; g <= Generations; g++ {
+		if _, err := os.Stat(generationFile(g)); err != nil {
+			continue
+		}
+
+		b := fileutil.ReadFile(generationFile(g))
+		if _, err := parseState(b); err != nil {
+			continue
+		}
+
+		corruptFile := fmt.Sprintf("%s.corrupt-%s", tscos.StateFile(), time.Now().Format("20060102-150405"))
+		fileutil.WriteFile(corruptFile, corrupt)
+		fileutil.WriteFile(tscos.StateFile(), b)
+
+		tsclog.Warnf("CLI state file is corrupt (%s), recovered it from backup generation %d; corrupt file saved to %s", cause, g, corruptFile)
+		return b
+	}
+
+	panic(errors.Wrapf(cause, "state file is corrupt and there is no valid backup generation to recover from; state-file=%s, backups-dir=%s",
+		tscos.StateFile(), tscos.StateBackupsDir()))
+}
+
+// MustUseFileBackend panics if state is served by another tscalectl (see state serve). Generations are kept only by
+// the local file backend, remote state is restored on the machine which serves it.
+func MustUseFileBackend(operation string) {
+	if _, ok := backend.(fileBackend); !ok {
+		panic(tscerr.Errorf(tscerr.BadInput, "%s, works only with local state file, run it where state is served", operation))
+	}
+}
+
+func parseState(b []byte) (*State, error) {
+	var s State
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func generationFile(generation int) string {
+	return fmt.Sprintf("%s/state.json.%d", tscos.StateBackupsDir(), generation)
+}
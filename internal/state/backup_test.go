@@ -0,0 +1,62 @@
+package state
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerationsRotatedOncePerInvocation(t *testing.T) {
+	useTempHome(t)
+	first := withInvocation(context.Background(), "first")
+	second := withInvocation(context.Background(), "second")
+
+	// up stores the new node and then every finished step
+	node := AddNewNode(first, &VPNNode{Provider: "simulate", Region: "eu-north-1", InstanceType: "t3.small"})
+	for _, step := range AllSteps(BootstrapSSH) {
+		node.CompleteStep(step)
+		UpdateNode(first, node)
+	}
+	if gens := ListGenerations(first); len(gens) != 1 {
+		t.Fatalf("ListGenerations() after one invocation = %d generations, want 1", len(gens))
+	}
+
+	RemoveNode(second, node.TscalectlID)
+	RemoveNode(second, node.TscalectlID)
+
+	gens := ListGenerations(second)
+	if len(gens) != 2 || gens[0].Nodes != 1 {
+		t.Fatalf("ListGenerations() after two invocations = %+v, want 2 generations, the newest with the provisioned node", gens)
+	}
+
+	// restore is always kept as a generation, so it can be undone
+	Restore(second, 1)
+	Restore(second, 1)
+	if gens = ListGenerations(second); len(gens) != 4 || gens[0].Nodes != 1 || len(GetState(second).Nodes) != 0 {
+		t.Fatalf("ListGenerations() after restores = %+v, want restore of restore back to empty state", gens)
+	}
+}
+
+func TestServedGenerationsRotatedOncePerInvocation(t *testing.T) {
+	useTempHome(t)
+	s := &server{file: fileBackend{}}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	b := NewHTTPBackend(ts.URL, "")
+	write := func() {
+		unlock := b.Lock(ctx)
+		defer unlock()
+
+		_, version := b.Read(ctx)
+		b.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), version)
+	}
+	for i := 0; i < 4; i++ {
+		write()
+	}
+
+	if gens := ListGenerations(ctx); len(gens) != 1 {
+		t.Fatalf("ListGenerations() after writes of one remote invocation = %d generations, want 1", len(gens))
+	}
+}
@@ -0,0 +1,206 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// httpBackend keeps state on the `tscalectl state serve` server, so a team can share one node inventory
+type httpBackend struct {
+	url    string
+	token  string
+	client *http.Client
+
+	leaseID string
+}
+
+type lease struct {
+	ID        string              `json:"lease_id"`
+	Holder    fileutil.LockHolder `json:"holder"`
+	ExpiresAt time.Time           `json:"expires_at"`
+}
+
+func NewHTTPBackend(url string, token string) Backend {
+	return &httpBackend{
+		url:    strings.TrimSuffix(url, "/"),
+		token:  token,
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// Lock acquires lock lease and renews it until unlocked. Lease expires on its own if this process dies or hangs.
+func (b *httpBackend) Lock(ctx context.Context) func() {
+	holder := fileutil.LockHolder{PID: os.Getpid(), Command: fileutil.LockCommand, StartedAt: time.Now()}
+
+	startTime := time.Now()
+	for {
+		resp, body := b.do(ctx, http.MethodPost, "/lock", holder, nil)
+		if resp.StatusCode == http.StatusOK {
+			var l lease
+			b.unmarshal(body, &l)
+			b.leaseID = l.ID
+			break
+		}
+		if resp.StatusCode != http.StatusLocked {
+			panic(b.statusError("lock state", resp, body))
+		}
+
+		if fileutil.LockTimeout > 0 && time.Since(startTime) > fileutil.LockTimeout {
+			var l lease
+			b.unmarshal(body, &l)
+			panic(tscerr.Errorf(tscerr.Timeout, "state is locked by %s, lock was not acquired in %s; state-url=%s", l.Holder, fileutil.LockTimeout, b.url))
+		}
+		interrupt.Sleep(ctx, time.Second)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(leaseTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// lost lease is noticed by the server on the next write
+				b.renew()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+
+		// lease is released even when ctx is canceled
+		resp, body := b.do(context.Background(), http.MethodDelete, "/lock/"+b.leaseID, nil, nil)
+		b.leaseID = ""
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+			panic(b.statusError("unlock state", resp, body))
+		}
+	}
+}
+
+func (b *httpBackend) renew() {
+	defer func() { _ = recover() }()
+	b.do(context.Background(), http.MethodPut, "/lock/"+b.leaseID, nil, nil)
+}
+
+func (b *httpBackend) Holder(ctx context.Context) (fileutil.LockHolder, bool) {
+	resp, body := b.do(ctx, http.MethodGet, "/lock", nil, nil)
+	if resp.StatusCode == http.StatusNoContent {
+		return fileutil.LockHolder{}, false
+	}
+	if resp.StatusCode != http.StatusOK {
+		panic(b.statusError("state lock holder", resp, body))
+	}
+
+	var l lease
+	b.unmarshal(body, &l)
+	return l.Holder, true
+}
+
+func (b *httpBackend) ForceUnlock(ctx context.Context) {
+	resp, body := b.do(ctx, http.MethodDelete, "/lock", nil, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		panic(b.statusError("force unlock state", resp, body))
+	}
+}
+
+func (b *httpBackend) Read(ctx context.Context) ([]byte, string) {
+	resp, body := b.do(ctx, http.MethodGet, "/state", nil, nil)
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, ""
+	}
+	if resp.StatusCode != http.StatusOK {
+		panic(b.statusError("read state", resp, body))
+	}
+
+	return body, strings.Trim(resp.Header.Get("ETag"), `"`)
+}
+
+func (b *httpBackend) Write(ctx context.Context, doc []byte, version string) string {
+	header := http.Header{}
+	header.Set(leaseHeader, b.leaseID)
+	header.Set(invocationHeader, Invocation)
+	if version == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", `"`+version+`"`)
+	}
+
+	resp, body := b.do(ctx, http.MethodPut, "/state", json.RawMessage(doc), header)
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		panic(errors.Wrapf(ErrVersionConflict, "write state; state-url=%s", b.url))
+	}
+	if resp.StatusCode != http.StatusOK {
+		panic(b.statusError("write state", resp, body))
+	}
+
+	return strings.Trim(resp.Header.Get("ETag"), `"`)
+}
+
+func (b *httpBackend) do(ctx context.Context, method string, path string, reqBody interface{}, header http.Header) (*http.Response, []byte) {
+	var r io.Reader
+	if reqBody != nil {
+		j, err := json.Marshal(reqBody)
+		if err != nil {
+			panic(errors.Wrap(err, "state http backend, json marshal"))
+		}
+		r = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.url+path, r)
+	if err != nil {
+		panic(errors.Wrapf(err, "state http backend, new request; state-url=%s", b.url))
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		panic(errors.Wrapf(err, "state http backend, %s %s; state-url=%s", method, path, b.url))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(errors.Wrapf(err, "state http backend, read body; state-url=%s", b.url))
+	}
+
+	return resp, body
+}
+
+func (b *httpBackend) unmarshal(body []byte, v interface{}) {
+	if err := json.Unmarshal(body, v); err != nil {
+		panic(errors.Wrapf(err, "state http backend, json unmarshal; state-url=%s", b.url))
+	}
+}
+
+func (b *httpBackend) statusError(operation string, resp *http.Response, body []byte) error {
+	kind := tscerr.Unknown
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		kind = tscerr.Auth
+	}
+	return tscerr.Errorf(kind, "%s, unexpected response; status=%s, body=%s, state-url=%s", operation, resp.Status, strings.TrimSpace(string(body)), b.url)
+}
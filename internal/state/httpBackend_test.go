@@ -0,0 +1,162 @@
+package state
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// newTestServer serves state of a temporary home directory, returned server allows tests to change its lease
+func newTestServer(t *testing.T, token string) (*server, string) {
+	t.Helper()
+
+	useTempHome(t)
+	s := &server{token: token, file: fileBackend{}}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+
+	return s, ts.URL
+}
+
+func TestHTTPBackendReadWrite(t *testing.T) {
+	_, url := newTestServer(t, "token")
+	ctx := context.Background()
+	b := NewHTTPBackend(url, "token")
+
+	unlock := b.Lock(ctx)
+	defer unlock()
+
+	doc, version := b.Read(ctx)
+	if doc != nil || version != "" {
+		t.Fatalf("Read() of empty state = %q, %q, want empty", doc, version)
+	}
+
+	v1 := b.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "")
+	doc, version = b.Read(ctx)
+	if version != v1 || string(doc) != `{"schema_version":1,"nodes":{}}` {
+		t.Fatalf("Read() = %s, %q, want written document with ETag %q", doc, version, v1)
+	}
+
+	// document exists, so it may not be created again
+	err := catch(func() { b.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "") })
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Write() without version of existing state error = %v, want %v", err, ErrVersionConflict)
+	}
+}
+
+func TestHTTPBackendVersionConflict(t *testing.T) {
+	_, url := newTestServer(t, "")
+	ctx := context.Background()
+	a, b := NewHTTPBackend(url, ""), NewHTTPBackend(url, "")
+
+	unlock := a.Lock(ctx)
+	v1 := a.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "")
+	unlock()
+
+	// b changes state between read and write of a, e.g. a read state without the lock
+	unlock = b.Lock(ctx)
+	b.Write(ctx, []byte(`{"schema_version":1,"nodes":{},"last_id":1}`), v1)
+	unlock()
+
+	unlock = a.Lock(ctx)
+	defer unlock()
+
+	err := catch(func() { a.Write(ctx, []byte(`{"schema_version":1,"nodes":{},"last_id":2}`), v1) })
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Write() with stale ETag error = %v, want %v", err, ErrVersionConflict)
+	}
+	if doc, _ := a.Read(ctx); string(doc) != `{"schema_version":1,"nodes":{},"last_id":1}` {
+		t.Fatalf("Read() = %s, want document of the first writer", doc)
+	}
+}
+
+func TestHTTPBackendLease(t *testing.T) {
+	_, url := newTestServer(t, "")
+	ctx := context.Background()
+	a, b := NewHTTPBackend(url, ""), NewHTTPBackend(url, "")
+
+	oldTimeout := fileutil.LockTimeout
+	fileutil.LockTimeout = time.Nanosecond
+	t.Cleanup(func() { fileutil.LockTimeout = oldTimeout })
+
+	unlock := a.Lock(ctx)
+
+	if holder, ok := b.Holder(ctx); !ok || holder.PID == 0 {
+		t.Fatalf("Holder() = %+v, %t, want holder of the lease", holder, ok)
+	}
+	if err := catch(func() { b.Lock(ctx) }); !tscerr.Is(err, tscerr.Timeout) {
+		t.Fatalf("Lock() of locked state error = %v, want timeout", err)
+	}
+
+	// write without the lease is rejected while another CLI holds it
+	if err := catch(func() { b.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "") }); err == nil {
+		t.Fatalf("Write() without lease did not fail")
+	}
+
+	unlock()
+	if _, ok := b.Holder(ctx); ok {
+		t.Fatalf("Holder() reports lease after unlock")
+	}
+
+	unlock = b.Lock(ctx)
+	unlock()
+}
+
+func TestHTTPBackendExpiredLease(t *testing.T) {
+	s, url := newTestServer(t, "")
+	ctx := context.Background()
+	a, b := NewHTTPBackend(url, ""), NewHTTPBackend(url, "")
+
+	unlockA := a.Lock(ctx)
+
+	// a hangs until its lease expires and b takes the lock
+	s.mu.Lock()
+	s.lease.ExpiresAt = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+
+	unlockB := b.Lock(ctx)
+	defer unlockB()
+
+	if err := catch(func() { a.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "") }); err == nil {
+		t.Fatalf("Write() with expired lease did not fail")
+	}
+	// lease of a is gone, unlock must not release lease of b
+	if err := catch(unlockA); err != nil {
+		t.Fatalf("unlock of expired lease error = %v", err)
+	}
+	if _, ok := a.Holder(ctx); !ok {
+		t.Fatalf("Holder() reports no lease, unlock of expired lease released lease of another CLI")
+	}
+
+	b.Write(ctx, []byte(`{"schema_version":1,"nodes":{}}`), "")
+}
+
+func TestHTTPBackendForceUnlock(t *testing.T) {
+	_, url := newTestServer(t, "")
+	ctx := context.Background()
+	a, b := NewHTTPBackend(url, ""), NewHTTPBackend(url, "")
+
+	unlock := a.Lock(ctx)
+	defer func() { _ = catch(unlock) }()
+
+	b.ForceUnlock(ctx)
+	if _, ok := b.Holder(ctx); ok {
+		t.Fatalf("Holder() reports lease after force unlock")
+	}
+}
+
+func TestHTTPBackendToken(t *testing.T) {
+	_, url := newTestServer(t, "token")
+	ctx := context.Background()
+
+	err := catch(func() { NewHTTPBackend(url, "wrong").Read(ctx) })
+	if !tscerr.Is(err, tscerr.Auth) {
+		t.Fatalf("Read() with wrong token error = %v, want auth error", err)
+	}
+}
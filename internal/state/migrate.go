@@ -0,0 +1,198 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// CurrentSchemaVersion is the version of state file written by this tscalectl version.
+// Every change which older versions can't read properly bumps it and adds a migration.
+const CurrentSchemaVersion = 3
+
+type migration struct {
+	description string
+	migrate     func(doc map[string]interface{})
+}
+
+// migrations[i] upgrades state file from schema version i to i+1. Migrations work on plain JSON documents,
+// so they keep working after VPNNode changes in the future.
+var migrations = []migration{
+	{
+		description: "store provider, bootstrap and finished provisioning steps of nodes created before they were tracked",
+		migrate:     migrateV0,
+	},
+	{
+		// older versions would drop these fields when they rewrite the state
+		description: "nodes carry status and cleanup mark of state refresh",
+		migrate:     func(doc map[string]interface{}) {},
+	},
+	{
+		// older versions would drop the field and treat imported nodes as reachable over SSH
+		description: "nodes imported without private key are marked as not reachable over SSH",
+		migrate:     func(doc map[string]interface{}) {},
+	},
+}
+
+type MigrationReport struct {
+	FromVersion int
+	ToVersion   int
+	Migrations  []string
+	// Changes are human readable changes of the state file, e.g. nodes.3.provider: <unset> -> "aws"
+	Changes []string
+}
+
+// Migrate upgrades state file to the current schema version, with dryRun the file is left untouched
+func Migrate(ctx context.Context, dryRun bool) MigrationReport {
+	unlock := backend.Lock(ctx)
+	defer unlock()
+
+	b, version := backend.Read(ctx)
+	if len(b) == 0 {
+		return MigrationReport{FromVersion: CurrentSchemaVersion, ToVersion: CurrentSchemaVersion}
+	}
+
+	before := unmarshalDoc(b)
+	after := unmarshalDoc(b)
+
+	report := migrate(after)
+	report.Changes = diff("", before, after)
+
+	if !dryRun && len(report.Migrations) > 0 {
+		backend.Write(ctx, marshalDoc(after), version)
+	}
+
+	return report
+}
+
+func migrate(doc map[string]interface{}) MigrationReport {
+	version := schemaVersion(doc)
+	if version > CurrentSchemaVersion {
+		panic(tscerr.Errorf(tscerr.BadInput, "state file schema version %d is newer than version %d supported by this tscalectl, "+
+			"please upgrade tscalectl; state-file=%s", version, CurrentSchemaVersion, tscos.StateFile()))
+	}
+
+	report := MigrationReport{FromVersion: version, ToVersion: CurrentSchemaVersion}
+	for v := version; v < CurrentSchemaVersion; v++ {
+		migrations[v].migrate(doc)
+		doc["schema_version"] = v + 1
+		report.Migrations = append(report.Migrations, fmt.Sprintf("v%d -> v%d: %s", v, v+1, migrations[v].description))
+	}
+
+	return report
+}
+
+// schemaVersion of the document, files written before versioning was introduced don't have it (version 0)
+func schemaVersion(doc map[string]interface{}) int {
+	v, ok := doc["schema_version"]
+	if !ok {
+		return 0
+	}
+
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != float64(int(f)) {
+		panic(tscerr.Errorf(tscerr.BadInput, "state file has invalid schema version; schema-version=%v", v))
+	}
+
+	return int(f)
+}
+
+func migrateV0(doc map[string]interface{}) {
+	nodes, _ := doc["nodes"].(map[string]interface{})
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// before providers were introduced, every node was created in AWS
+		if p, _ := node["provider"].(string); p == "" {
+			node["provider"] = "aws"
+		}
+		if b, _ := node["bootstrap"].(string); b == "" {
+			node["bootstrap"] = BootstrapSSH
+		}
+
+		// before steps were tracked, node stayed in the state only if up finished (or failed without cleanup),
+		// so there is nothing to resume
+		if _, ok := node["steps"]; !ok {
+			node["steps"] = []interface{}{
+				string(StepSSHKeysCreated),
+				string(StepKeyImported),
+				string(StepSecurityGroupCreated),
+				string(StepInstanceLaunched),
+				string(StepKnownHostsUpdated),
+				string(StepTailscaleInstalled),
+				string(StepTailscaleUp),
+			}
+		}
+	}
+}
+
+func unmarshalDoc(b []byte) map[string]interface{} {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		panic(errors.Wrap(err, "get state, json unmarshal"))
+	}
+	return doc
+}
+
+func marshalDoc(doc map[string]interface{}) []byte {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		panic(errors.Wrap(err, "store state, json marshal"))
+	}
+	return b
+}
+
+func diff(path string, before interface{}, after interface{}) []string {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if !beforeIsMap || !afterIsMap {
+		if reflect.DeepEqual(before, after) {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: %s -> %s", path, diffValue(before), diffValue(after))}
+	}
+
+	keys := make([]string, 0, len(beforeMap)+len(afterMap))
+	for k := range beforeMap {
+		keys = append(keys, k)
+	}
+	for k := range afterMap {
+		if _, ok := beforeMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []string
+	for _, k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		changes = append(changes, diff(p, beforeMap[k], afterMap[k])...)
+	}
+
+	return changes
+}
+
+func diffValue(v interface{}) string {
+	if v == nil {
+		return "<unset>"
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
@@ -0,0 +1,156 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// stateV0 is a state file written before schema versions, provider, bootstrap and steps were introduced
+const stateV0 = `{"nodes":{"3":{"tscalectl_id":3,"tscalectl_name":"003-eu-north-1-t3.small","region":"eu-north-1"},` +
+	`"4":{"tscalectl_id":4,"tscalectl_name":"004-eu-north-1-t3.small","region":"eu-north-1","provider":"simulate",` +
+	`"steps":["ssh_keys_created"]}},"last_id":5}`
+
+func storeDoc(t *testing.T, doc string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	unlock := backend.Lock(ctx)
+	defer unlock()
+
+	_, version := backend.Read(ctx)
+	return backend.Write(ctx, []byte(doc), version)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+	version := storeDoc(t, stateV0)
+
+	report := Migrate(ctx, true)
+
+	if report.FromVersion != 0 || report.ToVersion != CurrentSchemaVersion || len(report.Migrations) != CurrentSchemaVersion {
+		t.Fatalf("Migrate() report = %+v, want every migration from version 0", report)
+	}
+	for _, change := range []string{
+		`nodes.3.provider: <unset> -> "aws"`,
+		`nodes.3.bootstrap: <unset> -> "ssh"`,
+		fmt.Sprintf("schema_version: <unset> -> %d", CurrentSchemaVersion),
+	} {
+		if !contains(report.Changes, change) {
+			t.Errorf("Migrate() changes = %q, want %q", report.Changes, change)
+		}
+	}
+
+	doc, current := backend.Read(ctx)
+	if current != version || string(doc) != stateV0 {
+		t.Fatalf("Migrate() with dry run changed state file: %s", doc)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+	storeDoc(t, stateV0)
+
+	Migrate(ctx, false)
+
+	s := GetState(ctx)
+	if s.SchemaVersion != CurrentSchemaVersion || s.LastID != 5 {
+		t.Fatalf("GetState() schema version = %d, last ID = %d, want %d and 5", s.SchemaVersion, s.LastID, CurrentSchemaVersion)
+	}
+
+	old := s.Nodes[3]
+	if old.Provider != "aws" || old.Bootstrap != BootstrapSSH {
+		t.Fatalf("migrated node provider = %q, bootstrap = %q, want aws and ssh", old.Provider, old.Bootstrap)
+	}
+	if !reflect.DeepEqual(old.Steps, AllSteps(BootstrapSSH)) {
+		t.Fatalf("migrated node steps = %v, want all steps", old.Steps)
+	}
+
+	// values which already exist are kept
+	tracked := s.Nodes[4]
+	if tracked.Provider != "simulate" || !reflect.DeepEqual(tracked.Steps, []Step{StepSSHKeysCreated}) {
+		t.Fatalf("migrated node provider = %q, steps = %v, want values from state file", tracked.Provider, tracked.Steps)
+	}
+
+	if report := Migrate(ctx, false); len(report.Migrations) != 0 || len(report.Changes) != 0 {
+		t.Fatalf("Migrate() of current state file = %+v, want no migrations", report)
+	}
+}
+
+func TestGetStateMigrates(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+	storeDoc(t, stateV0)
+
+	if n := GetNode(ctx, 3); n.Provider != "aws" {
+		t.Fatalf("GetNode() provider = %q, want aws", n.Provider)
+	}
+
+	// upgraded state is written back
+	doc, _ := backend.Read(ctx)
+	if schemaVersion(unmarshalDoc(doc)) != CurrentSchemaVersion {
+		t.Fatalf("state file was not migrated: %s", doc)
+	}
+}
+
+func TestMigrateInvalidVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{name: "newer version", doc: `{"schema_version":99,"nodes":{}}`},
+		{name: "negative", doc: `{"schema_version":-1,"nodes":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempHome(t)
+			storeDoc(t, tt.doc)
+
+			err := catch(func() { Migrate(context.Background(), true) })
+			if !tscerr.Is(err, tscerr.BadInput) {
+				t.Fatalf("Migrate() error = %v, want bad input", err)
+			}
+			err = catch(func() { GetState(context.Background()) })
+			if !tscerr.Is(err, tscerr.BadInput) {
+				t.Fatalf("GetState() error = %v, want bad input", err)
+			}
+		})
+	}
+}
+
+// schema versions of wrong type are rejected by the file backend as a corrupt file, other backends leave them to migrate
+// state written before status of refresh was stored is kept as it is
+func TestMigrateV1(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+	doc := `{"schema_version":1,"nodes":{"0":{"tscalectl_id":0,"provider":"aws","bootstrap":"ssh"}},"last_id":1}`
+	storeDoc(t, doc)
+
+	report := Migrate(ctx, false)
+	if report.FromVersion != 1 || len(report.Changes) != 1 || report.Changes[0] != fmt.Sprintf("schema_version: 1 -> %d", CurrentSchemaVersion) {
+		t.Fatalf("Migrate() report = %+v, want only schema version change", report)
+	}
+}
+
+func TestMigrateInvalidVersionType(t *testing.T) {
+	for _, doc := range []string{`{"schema_version":"1","nodes":{}}`, `{"schema_version":0.5,"nodes":{}}`} {
+		err := catch(func() { migrate(unmarshalDoc([]byte(doc))) })
+		if !tscerr.Is(err, tscerr.BadInput) {
+			t.Errorf("migrate(%s) error = %v, want bad input", doc, err)
+		}
+	}
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
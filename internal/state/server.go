@@ -0,0 +1,212 @@
+package state
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+)
+
+// leaseTTL is how long the lock lease lives without renewal
+const leaseTTL = time.Second * 30
+
+const leaseHeader = "X-Tscalectl-Lease"
+
+// invocationHeader carries Invocation of the writing CLI, served state rotates generations once per CLI command
+const invocationHeader = "X-Tscalectl-Invocation"
+
+// server implements the HTTP backend on top of the local file backend
+type server struct {
+	token string
+	file  Backend
+
+	mu    sync.Mutex
+	lease *lease
+}
+
+// NewServer returns handler which serves local state to HTTP backends of other CLIs. Empty token disables authorization.
+func NewServer(token string) http.Handler {
+	return (&server{token: token, file: fileBackend{}}).handler()
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", s.handleState)
+	mux.HandleFunc("/lock", s.handleLock)
+	mux.HandleFunc("/lock/", s.handleLease)
+
+	return s.authorize(mux)
+}
+
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) handleState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.withFile(w, r, func() {
+			b, version := s.file.Read(r.Context())
+			if len(b) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("ETag", `"`+version+`"`)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(b)
+		})
+
+	case http.MethodPut:
+		if l := s.activeLease(); l != nil && l.ID != r.Header.Get(leaseHeader) {
+			writeJSON(w, http.StatusLocked, l)
+			return
+		}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err = parseState(b); err != nil {
+			http.Error(w, "invalid state document: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		version := strings.Trim(r.Header.Get("If-Match"), `"`)
+		if version == "" && r.Header.Get("If-None-Match") != "*" {
+			http.Error(w, "If-Match or If-None-Match header is required", http.StatusPreconditionRequired)
+			return
+		}
+
+		s.withFile(w, r, func() {
+			if _, current := s.file.Read(r.Context()); current != version {
+				http.Error(w, ErrVersionConflict.Error(), http.StatusPreconditionFailed)
+				return
+			}
+			ctx := withInvocation(r.Context(), r.Header.Get(invocationHeader))
+			w.Header().Set("ETag", `"`+s.file.Write(ctx, b, version)+`"`)
+			w.WriteHeader(http.StatusOK)
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleLock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if l := s.activeLease(); l != nil {
+			writeJSON(w, http.StatusOK, l)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		var holder fileutil.LockHolder
+		if err := json.NewDecoder(r.Body).Decode(&holder); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.lease != nil && time.Now().Before(s.lease.ExpiresAt) {
+			writeJSON(w, http.StatusLocked, s.lease)
+			return
+		}
+
+		s.lease = &lease{ID: randomLeaseID(), Holder: holder, ExpiresAt: time.Now().Add(leaseTTL)}
+		writeJSON(w, http.StatusOK, s.lease)
+
+	case http.MethodDelete:
+		// force unlock
+		s.mu.Lock()
+		s.lease = nil
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleLease(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/lock/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lease == nil || s.lease.ID != id || time.Now().After(s.lease.ExpiresAt) {
+		http.Error(w, "lease does not exist or expired", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.lease.ExpiresAt = time.Now().Add(leaseTTL)
+		writeJSON(w, http.StatusOK, s.lease)
+	case http.MethodDelete:
+		s.lease = nil
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) activeLease() *lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lease == nil || time.Now().After(s.lease.ExpiresAt) {
+		return nil
+	}
+	l := *s.lease
+	return &l
+}
+
+// withFile runs fn under the state file lock, so local CLIs using the same file stay consistent with the server
+func (s *server) withFile(w http.ResponseWriter, r *http.Request, fn func()) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			http.Error(w, fmt.Sprintf("%v", rec), http.StatusInternalServerError)
+		}
+	}()
+
+	unlock := s.file.Lock(r.Context())
+	defer unlock()
+
+	fn()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomLeaseID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,9 +1,9 @@
 package state
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -11,12 +11,17 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
-	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
 )
 
 type State struct {
-	Nodes  map[int]*VPNNode `json:"nodes"`
-	LastID int              `json:"last_id"`
+	SchemaVersion int              `json:"schema_version"`
+	Nodes         map[int]*VPNNode `json:"nodes"`
+	LastID        int              `json:"last_id"`
+
+	// version of the state document which was read, see Backend
+	version string
 }
 
 type VPNNode struct {
@@ -24,79 +29,191 @@ type VPNNode struct {
 	TscalectlName string    `json:"tscalectl_name"`
 	CreatedAt     time.Time `json:"created_at"`
 
+	Provider     string `json:"provider,omitempty"`
 	Region       string `json:"region"`
 	InstanceType string `json:"instance_type"`
 	AMI          string `json:"ami"`
+	ExitNode     bool   `json:"exit_node"`
+
+	// Bootstrap is the way tailscale is started on the node, empty means BootstrapSSH
+	Bootstrap        string   `json:"bootstrap,omitempty"`
+	CloudConfigFiles []string `json:"cloud_config_files,omitempty"`
+
+	// cloud resource IDs and instance placement, stored as up learns them (nodes created by older
+	// tscalectl versions don't have them, their resources are found by name)
+	KeyPairID        string `json:"key_pair_id,omitempty"`
+	SecurityGroupID  string `json:"security_group_id,omitempty"`
+	InstanceID       string `json:"instance_id,omitempty"`
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+	SubnetID         string `json:"subnet_id,omitempty"`
+	PublicIP         string `json:"public_ip,omitempty"`
+	PrivateIP        string `json:"private_ip,omitempty"`
+
+	// Steps are finished provisioning steps, in order in which they were finished
+	Steps []Step `json:"steps,omitempty"`
+
+	// Status is the instance status found by the last `state refresh`, empty if node was never refreshed
+	Status string `json:"status,omitempty"`
+	// NoSSHAccess nodes were imported without private key, CLI can't connect to them
+	NoSSHAccess bool `json:"no_ssh_access,omitempty"`
+
+	// MarkedForCleanup nodes are dead, their leftover resources wait to be deleted with `down`
+	MarkedForCleanup bool `json:"marked_for_cleanup,omitempty"`
 }
 
-func AddNewNode(region string, instanceType string, ami string) *VPNNode {
-	fileutil.MkdirAll(tscos.TscalectlDir())
+const (
+	StatusRunning    = "running"
+	StatusStopped    = "stopped"
+	StatusTerminated = "terminated"
+	StatusMissing    = "missing"
+	// StatusProvisioning node has no instance yet, up is running or failed (and kept resources) before launching it
+	StatusProvisioning = "provisioning"
+)
 
-	unlock := fileutil.Lock(tscos.StateFile())
-	defer unlock()
+const (
+	BootstrapSSH       = "ssh"
+	BootstrapCloudInit = "cloud-init"
+)
 
-	s := getState()
+// Step is a provisioning step of the up command
+type Step string
+
+const (
+	StepSSHKeysCreated       Step = "ssh_keys_created"
+	StepKeyImported          Step = "key_imported"
+	StepSecurityGroupCreated Step = "security_group_created"
+	StepInstanceLaunched     Step = "instance_launched"
+	StepKnownHostsUpdated    Step = "known_hosts_updated"
+	StepTailscaleInstalled   Step = "tailscale_installed"
+	StepTailscaleUp          Step = "tailscale_up"
+)
 
-	tscalectlID := s.getNextTscalectlID()
-	tscalectlIDStr := strconv.Itoa(tscalectlID)
+// AllSteps are steps of fully provisioned node
+func AllSteps(bootstrap string) []Step {
+	if bootstrap == BootstrapCloudInit {
+		return []Step{StepSSHKeysCreated, StepKeyImported, StepSecurityGroupCreated, StepInstanceLaunched, StepTailscaleUp}
+	}
+	return []Step{StepSSHKeysCreated, StepKeyImported, StepSecurityGroupCreated, StepInstanceLaunched,
+		StepKnownHostsUpdated, StepTailscaleInstalled, StepTailscaleUp}
+}
 
-	if tscalectlID < 10 {
-		tscalectlIDStr = leftPad(2, "0", tscalectlIDStr)
-	} else if tscalectlID < 100 {
-		tscalectlIDStr = leftPad(1, "0", tscalectlIDStr)
+func (n *VPNNode) Done(step Step) bool {
+	for _, s := range n.Steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *VPNNode) CompleteStep(step Step) {
+	if !n.Done(step) {
+		n.Steps = append(n.Steps, step)
 	}
+}
 
-	node := &VPNNode{
-		TscalectlID:   tscalectlID,
-		TscalectlName: fmt.Sprintf("%s-%s-%s", tscalectlIDStr, region, instanceType),
-		CreatedAt:     time.Now(),
-		Region:        region,
-		InstanceType:  instanceType,
-		AMI:           ami,
+// UndoStep marks step as not finished. Steps finished after it are marked as not finished too,
+// because they depend on the undone one (e.g. tailscale can't stay installed on terminated instance).
+func (n *VPNNode) UndoStep(step Step) {
+	for i, s := range n.Steps {
+		if s == step {
+			n.Steps = n.Steps[:i]
+			return
+		}
 	}
+}
+
+// AddNewNode stores node under the next free ID, node name is derived from the ID, region and instance type
+func AddNewNode(ctx context.Context, node *VPNNode) *VPNNode {
+	unlock := backend.Lock(ctx)
+	defer unlock()
+
+	s := getState(ctx)
+
+	tscalectlID := s.getNextTscalectlID()
+
+	node.TscalectlID = tscalectlID
+	node.TscalectlName = NodeName(tscalectlID, node.Region, node.InstanceType)
+	node.CreatedAt = time.Now()
 	s.Nodes[tscalectlID] = node
 
-	storeState(s)
+	storeState(ctx, s)
 
 	return node
 }
 
-func GetNode(tscalectlID int) *VPNNode {
-	fileutil.MkdirAll(tscos.TscalectlDir())
+// ImportNodes stores nodes which already exist in the cloud. Node keeps ID from its name if it is free, otherwise it
+// gets the next free ID (its name and cloud resources keep the old one).
+func ImportNodes(ctx context.Context, nodes []*VPNNode) {
+	unlock := backend.Lock(ctx)
+	defer unlock()
+
+	s := getState(ctx)
+
+	for _, node := range nodes {
+		id, _, _, ok := ParseNodeName(node.TscalectlName)
+		if _, taken := s.Nodes[id]; !ok || taken {
+			id = s.getNextTscalectlID()
+			for s.Nodes[id] != nil {
+				id = s.getNextTscalectlID()
+			}
+		} else if id >= s.LastID {
+			// IDs assigned to new nodes must not collide with imported ones
+			s.LastID = (id + 1) % 1000
+		}
+
+		node.TscalectlID = id
+		s.Nodes[id] = node
+	}
 
-	unlock := fileutil.Lock(tscos.StateFile())
+	storeState(ctx, s)
+}
+
+func GetNode(ctx context.Context, tscalectlID int) *VPNNode {
+	unlock := backend.Lock(ctx)
 	defer unlock()
 
-	s := getState()
+	s := getState(ctx)
 
 	node, ok := s.Nodes[tscalectlID]
 	if !ok {
-		panic(errors.New("node with provided ID does not exist in CLI state"))
+		panic(tscerr.Errorf(tscerr.NotFound, "node with provided ID does not exist in CLI state; id=%d", tscalectlID))
 	}
 
 	return node
 }
 
-func RemoveNode(tscalectlID int) {
-	fileutil.MkdirAll(tscos.TscalectlDir())
+// UpdateNode replaces stored node with the provided one
+func UpdateNode(ctx context.Context, node *VPNNode) {
+	unlock := backend.Lock(ctx)
+	defer unlock()
+
+	s := getState(ctx)
+
+	if _, ok := s.Nodes[node.TscalectlID]; !ok {
+		panic(tscerr.Errorf(tscerr.NotFound, "node with provided ID does not exist in CLI state; id=%d", node.TscalectlID))
+	}
+	s.Nodes[node.TscalectlID] = node
+
+	storeState(ctx, s)
+}
 
-	unlock := fileutil.Lock(tscos.StateFile())
+func RemoveNode(ctx context.Context, tscalectlID int) {
+	unlock := backend.Lock(ctx)
 	defer unlock()
 
-	s := getState()
+	s := getState(ctx)
 
 	delete(s.Nodes, tscalectlID)
 
-	storeState(s)
+	storeState(ctx, s)
 }
 
-func GetState() *State {
-	fileutil.MkdirAll(tscos.TscalectlDir())
-
-	unlock := fileutil.Lock(tscos.StateFile())
+func GetState(ctx context.Context) *State {
+	unlock := backend.Lock(ctx)
 	defer unlock()
 
-	return getState()
+	return getState(ctx)
 }
 
 func (s *State) getNextTscalectlID() int {
@@ -105,20 +222,53 @@ func (s *State) getNextTscalectlID() int {
 	return id
 }
 
+// NodeName is also the name of node cloud resources, e.g. 003-eu-north-1-t3.small
+func NodeName(tscalectlID int, region string, instanceType string) string {
+	tscalectlIDStr := strconv.Itoa(tscalectlID)
+
+	if tscalectlID < 10 {
+		tscalectlIDStr = leftPad(2, "0", tscalectlIDStr)
+	} else if tscalectlID < 100 {
+		tscalectlIDStr = leftPad(1, "0", tscalectlIDStr)
+	}
+
+	return fmt.Sprintf("%s-%s-%s", tscalectlIDStr, region, instanceType)
+}
+
+// ParseNodeName is the reverse of NodeName, region contains dashes, so ID ends at the first and instance type
+// starts after the last one
+func ParseNodeName(name string) (tscalectlID int, region string, instanceType string, ok bool) {
+	first := strings.Index(name, "-")
+	last := strings.LastIndex(name, "-")
+	if first != 3 || last <= first+1 || last == len(name)-1 {
+		return 0, "", "", false
+	}
+
+	id, err := strconv.Atoi(name[:first])
+	if err != nil || id < 0 {
+		return 0, "", "", false
+	}
+
+	return id, name[first+1 : last], name[last+1:], true
+}
+
 func leftPad(pads int, padChars string, s string) string {
 	return strings.Repeat(padChars, pads) + s
 }
 
-func getState() *State {
-	if _, err := os.Stat(tscos.StateFile()); os.IsNotExist(err) {
-		return &State{Nodes: make(map[int]*VPNNode)}
-	} else if err != nil {
-		panic(errors.Wrap(err, "get state, os stat"))
+func getState(ctx context.Context) *State {
+	b, version := backend.Read(ctx)
+	if len(b) == 0 {
+		return &State{SchemaVersion: CurrentSchemaVersion, Nodes: make(map[int]*VPNNode), version: version}
 	}
 
-	b := fileutil.ReadFile(tscos.StateFile())
-	if len(b) == 0 {
-		return &State{Nodes: make(map[int]*VPNNode)}
+	doc := unmarshalDoc(b)
+	report := migrate(doc)
+	if len(report.Migrations) > 0 {
+		// callers hold the state lock, so upgraded state can be stored right away
+		b = marshalDoc(doc)
+		version = backend.Write(ctx, b, version)
+		tsclog.Warnf("Migrated CLI state from schema version %d to %d", report.FromVersion, report.ToVersion)
 	}
 
 	var state State
@@ -126,15 +276,31 @@ func getState() *State {
 	if err != nil {
 		panic(errors.Wrap(err, "get state, json unmarshal"))
 	}
+	if state.Nodes == nil {
+		state.Nodes = make(map[int]*VPNNode)
+	}
+	state.version = version
 
 	return &state
 }
 
-func storeState(s *State) {
+func storeState(ctx context.Context, s *State) {
+	s.SchemaVersion = CurrentSchemaVersion
+
 	b, err := json.Marshal(s)
 	if err != nil {
 		panic(errors.Wrap(err, "store state json marshal"))
 	}
 
-	fileutil.WriteFile(tscos.StateFile(), b)
+	s.version = backend.Write(ctx, b, s.version)
+}
+
+// LockHolder returns process holding the state lock, ok is false if state is not locked
+func LockHolder(ctx context.Context) (holder fileutil.LockHolder, ok bool) {
+	return backend.Holder(ctx)
+}
+
+// ForceUnlock clears the state lock, process holding it is not stopped
+func ForceUnlock(ctx context.Context) {
+	backend.ForceUnlock(ctx)
 }
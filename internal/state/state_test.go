@@ -0,0 +1,54 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// useTempHome keeps state files of the test in a temporary directory, with the local file backend
+func useTempHome(t *testing.T) {
+	t.Helper()
+
+	oldHome, oldContext, oldBackend := tscos.HomeDir(), tscos.Context, backend
+	tscos.SetHomeDir(t.TempDir())
+	tscos.Context = tscos.DefaultContext
+	backend = fileBackend{}
+	t.Cleanup(func() {
+		tscos.SetHomeDir(oldHome)
+		tscos.Context = oldContext
+		backend = oldBackend
+	})
+}
+
+func catch(fn func()) (err error) {
+	defer trycatch.ToError(&err)
+	fn()
+	return nil
+}
+
+func TestStateNodes(t *testing.T) {
+	useTempHome(t)
+	ctx := context.Background()
+
+	first := AddNewNode(ctx, &VPNNode{Provider: "simulate", Region: "eu-north-1", InstanceType: "t3.small"})
+	second := AddNewNode(ctx, &VPNNode{Provider: "simulate", Region: "eu-north-1", InstanceType: "t3.small"})
+	if first.TscalectlName != "000-eu-north-1-t3.small" || second.TscalectlName != "001-eu-north-1-t3.small" {
+		t.Fatalf("AddNewNode() names = %s, %s, want IDs 000 and 001", first.TscalectlName, second.TscalectlName)
+	}
+
+	second.CompleteStep(StepSSHKeysCreated)
+	UpdateNode(ctx, second)
+	if !GetNode(ctx, second.TscalectlID).Done(StepSSHKeysCreated) {
+		t.Fatalf("GetNode() lost completed step")
+	}
+
+	// IDs are not reused, names of removed nodes may still be used by leftovers in the cloud
+	RemoveNode(ctx, second.TscalectlID)
+	third := AddNewNode(ctx, &VPNNode{Provider: "simulate", Region: "eu-north-1", InstanceType: "t3.small"})
+	if third.TscalectlID != 2 {
+		t.Fatalf("AddNewNode() after remove ID = %d, want 2", third.TscalectlID)
+	}
+}
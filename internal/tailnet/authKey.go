@@ -0,0 +1,68 @@
+package tailnet
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+// APIKeyEnv is environment variable with tailscale API access token, it is needed only to check auth key expiry
+const APIKeyEnv = "TAILSCALE_API_KEY"
+
+const apiURL = "https://api.tailscale.com/api/v2"
+
+// AuthKey is auth key as described by tailscale API, the secret part of the key is never returned
+type AuthKey struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	// Invalid is set for revoked and expired keys
+	Invalid bool `json:"invalid"`
+}
+
+// GetAuthKey describes auth key with the ID, apiKey is tailscale API access token of the same tailnet
+func GetAuthKey(ctx context.Context, apiKey string, id string) AuthKey {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"/tailnet/-/keys/"+id, nil)
+	if err != nil {
+		panic(errors.Wrapf(err, "tailnet, new request; key-id=%s", id))
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(errors.Wrapf(err, "tailnet, get auth key; key-id=%s", id))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(errors.Wrapf(err, "tailnet, read body; key-id=%s", id))
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		panic(tscerr.Errorf(tscerr.Auth, "tailnet, api key was not accepted; env=%s, status=%s", APIKeyEnv, resp.Status))
+	case http.StatusNotFound:
+		panic(tscerr.Errorf(tscerr.NotFound, "tailnet, auth key does not exist in tailnet of api key (it was deleted or belongs to other tailnet); key-id=%s", id))
+	default:
+		panic(tscerr.Errorf(tscerr.Unknown, "tailnet, get auth key, unexpected response; key-id=%s, status=%s, body=%s", id, resp.Status, strings.TrimSpace(string(body))))
+	}
+
+	var key AuthKey
+	if err = json.Unmarshal(body, &key); err != nil {
+		panic(errors.Wrapf(err, "tailnet, json unmarshal auth key; key-id=%s", id))
+	}
+
+	return key
+}
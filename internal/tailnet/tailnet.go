@@ -0,0 +1,52 @@
+package tailnet
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type status struct {
+	Peer map[string]peer `json:"Peer"`
+}
+
+type peer struct {
+	HostName     string   `json:"HostName"`
+	DNSName      string   `json:"DNSName"`
+	TailscaleIPs []string `json:"TailscaleIPs"`
+}
+
+// IPv4s returns tailscale IPv4 addresses of peers by their hostname, as seen by the local tailscale CLI.
+// Map is empty if tailscale is not installed or not running on this machine.
+func IPv4s() map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "tailscale", "status", "--json").Output()
+	if err != nil {
+		return map[string]string{}
+	}
+
+	var s status
+	if err = json.Unmarshal(out, &s); err != nil {
+		return map[string]string{}
+	}
+
+	ips := make(map[string]string, len(s.Peer))
+	for _, p := range s.Peer {
+		for _, ip := range p.TailscaleIPs {
+			if strings.Contains(ip, ".") {
+				// node hostname can be changed in the admin console, DNS name then still starts with the original one
+				ips[p.HostName] = ip
+				if p.DNSName != "" {
+					ips[strings.SplitN(p.DNSName, ".", 2)[0]] = ip
+				}
+				break
+			}
+		}
+	}
+
+	return ips
+}
@@ -4,13 +4,17 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ToError stores recovered panic in err, error keeps its type (see tscerr), cause and stack. It is deferred by
+// command RunE functions, and by goroutines and loops which report errors to their caller instead of failing.
 func ToError(err *error) {
 	if r := recover(); r != nil {
-		switch x := r.(type) {
-		case error:
-			*err = errors.New(x.Error() + "\n")
-		default:
-			*err = errors.Errorf("unknown recovered type; val=%+v\n", x)
-		}
+		*err = toError(r)
 	}
 }
+
+func toError(r interface{}) error {
+	if x, ok := r.(error); ok {
+		return x
+	}
+	return errors.Errorf("unknown recovered type; val=%+v", r)
+}
@@ -0,0 +1,42 @@
+package tsccontext
+
+import (
+	"regexp"
+
+	"github.com/spf13/pflag"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+	"github.com/svennjegac/tailscale.node-provider/internal/userinput"
+)
+
+// context name is a directory name
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// MustBeValidName panics if name can't be name of a new context
+func MustBeValidName(name string) {
+	if !namePattern.MatchString(name) {
+		panic(tscerr.Errorf(tscerr.BadInput, "context, invalid name, use letters, digits, '_', '.' and '-'; name=%s", name))
+	}
+}
+
+// Name returns context chosen by flag, empty flag chooses current context of config, and default context if config
+// has none
+func Name(cfg config.Config, flag string) string {
+	if flag != "" {
+		return flag
+	}
+	if cfg.CurrentContext != "" {
+		return cfg.CurrentContext
+	}
+	return tscos.DefaultContext
+}
+
+// Use switches CLI to the context: its files are used from now on and its values become values of flags which are
+// not given on command line
+func Use(name string, c config.Context, flags *pflag.FlagSet) {
+	tscos.Context = name
+	userinput.DefaultRegion = c.Region
+	config.SetFlags(flags, c.Flags())
+}
@@ -0,0 +1,142 @@
+package tscerr
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Kind tells why an operation failed, every kind has its own process exit code
+type Kind int
+
+const (
+	Unknown Kind = iota
+	NotFound
+	Auth
+	Capacity
+	BadInput
+	Timeout
+	// Interrupted operation was canceled with Ctrl-C
+	Interrupted
+)
+
+// process exit codes, documented in README
+const (
+	ExitOK       = 0
+	ExitUnknown  = 1
+	ExitBadInput = 2
+	ExitNotFound = 3
+	ExitAuth     = 4
+	ExitCapacity = 5
+	ExitTimeout  = 6
+	// ExitInterrupted is the code shells use for processes stopped by SIGINT
+	ExitInterrupted = 130
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case Auth:
+		return "auth failure"
+	case Capacity:
+		return "capacity"
+	case BadInput:
+		return "bad input"
+	case Timeout:
+		return "timeout"
+	case Interrupted:
+		return "interrupted"
+	default:
+		return "unknown"
+	}
+}
+
+func (k Kind) ExitCode() int {
+	switch k {
+	case NotFound:
+		return ExitNotFound
+	case Auth:
+		return ExitAuth
+	case Capacity:
+		return ExitCapacity
+	case BadInput:
+		return ExitBadInput
+	case Timeout:
+		return ExitTimeout
+	case Interrupted:
+		return ExitInterrupted
+	default:
+		return ExitUnknown
+	}
+}
+
+// Error is an error of known kind, Err keeps the cause and its stack
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Cause makes errors.Cause of pkg/errors return the original cause
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Format prints stack of the cause with %+v
+func (e *Error) Format(s fmt.State, verb rune) {
+	if f, ok := e.Err.(fmt.Formatter); ok {
+		f.Format(s, verb)
+		return
+	}
+	fmt.Fprint(s, e.Err.Error())
+}
+
+func New(kind Kind, message string) error {
+	return &Error{Kind: kind, Err: errors.New(message)}
+}
+
+func Errorf(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Err: errors.Errorf(format, args...)}
+}
+
+func Wrap(kind Kind, err error, message string) error {
+	return &Error{Kind: kind, Err: errors.Wrap(err, message)}
+}
+
+func Wrapf(kind Kind, err error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Err: errors.Wrapf(err, format, args...)}
+}
+
+// KindOf returns kind of the outermost typed error in the chain, Unknown if there is none
+func KindOf(err error) Kind {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.Kind
+	}
+	// calls canceled by interrupt, whose errors were not typed on the way
+	if stderrors.Is(err, context.Canceled) {
+		return Interrupted
+	}
+	return Unknown
+}
+
+func Is(err error, kind Kind) bool {
+	return KindOf(err) == kind
+}
+
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	return KindOf(err).ExitCode()
+}
@@ -0,0 +1,218 @@
+package tsclog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+var (
+	// Verbosity is the lowest level printed to terminal, -v lowers it to Debug and -q raises it to Warn
+	Verbosity = Info
+	// Format of entries printed to terminal, text prints only messages, json prints one JSON object per entry
+	Format = Text
+)
+
+func MustBeValidFormat(format string) {
+	if format != Text && format != JSON {
+		panic(tscerr.Errorf(tscerr.BadInput, "tsclog, invalid log format; log-format=%s, allowed-log-formats=%+v", format, []string{Text, JSON}))
+	}
+}
+
+// entry is written to terminal and node log, json tags are the schema of JSON logs
+type entry struct {
+	Time   time.Time `json:"time"`
+	Level  string    `json:"level"`
+	Node   string    `json:"node,omitempty"`
+	Host   string    `json:"host,omitempty"`
+	Stream string    `json:"stream,omitempty"`
+	Msg    string    `json:"msg"`
+
+	level Level
+}
+
+// text is entry message, remote command output is prefixed with its host and stream
+func (e entry) text() string {
+	if e.Stream == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("[%s %s] %s", e.Host, e.Stream, e.Msg)
+}
+
+var (
+	// mu guards node log and keeps lines of parallel writers apart
+	mu       sync.Mutex
+	nodeFile *os.File
+	nodeName string
+)
+
+func Debugf(format string, a ...interface{}) {
+	write(entry{level: Debug, Msg: fmt.Sprintf(format, a...)}, true)
+}
+
+func Infof(format string, a ...interface{}) {
+	write(entry{level: Info, Msg: fmt.Sprintf(format, a...)}, true)
+}
+
+func Warnf(format string, a ...interface{}) {
+	write(entry{level: Warn, Msg: fmt.Sprintf(format, a...)}, true)
+}
+
+func write(e entry, terminal bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e.Time = time.Now().UTC()
+	e.Level = e.level.String()
+	e.Node = nodeName
+	// secrets never reach terminal or node log, e.g. tailscale up output or a failure with a key in it
+	e.Msg = redact.String(e.Msg)
+
+	if terminal && e.level >= Verbosity {
+		// warnings go to stderr, so they are seen even when stdout is redirected
+		w := output.ProgressWriter()
+		if e.level >= Warn {
+			w = os.Stderr
+		}
+
+		if Format == JSON {
+			b, err := json.Marshal(e)
+			if err != nil {
+				panic(errors.Wrap(err, "tsclog, json marshal entry"))
+			}
+			fmt.Fprintln(w, string(b))
+		} else {
+			fmt.Fprintln(w, e.text())
+		}
+	}
+
+	// node log is a full transcript, it has every level
+	if nodeFile != nil {
+		fmt.Fprintf(nodeFile, "%s %-5s %s\n", e.Time.Format(time.RFC3339), e.Level, e.text())
+	}
+}
+
+// NodeLog is transcript of commands which work on a node, see OpenNode
+type NodeLog struct {
+	f *os.File
+}
+
+// OpenNode starts writing every log entry, debug ones included, to log file of the node. New node starts a new file,
+// other commands (e.g. up --resume, down) append to it. Returned log must be closed with deferred Close.
+func OpenNode(name string, truncate bool) *NodeLog {
+	fileutil.MkdirAll(tscos.LogsDir())
+
+	flag := os.O_APPEND | os.O_WRONLY | os.O_CREATE
+	if truncate {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(tscos.NodeLogFile(name), flag, 0600)
+	if err != nil {
+		panic(errors.Wrap(err, "tsclog, open node log"))
+	}
+
+	mu.Lock()
+	nodeFile = f
+	nodeName = name
+	mu.Unlock()
+
+	write(entry{level: Info, Msg: "--- " + strings.Join(os.Args, " ")}, false)
+
+	return &NodeLog{f: f}
+}
+
+// Close must be deferred. If surrounding function panics, the failure is written to node log (terminal gets it
+// from the command) and panic is propagated further.
+func (l *NodeLog) Close() {
+	rec := recover()
+	if rec != nil {
+		write(entry{level: Error, Msg: fmt.Sprint(rec)}, false)
+	}
+
+	mu.Lock()
+	if nodeFile == l.f {
+		nodeFile = nil
+		nodeName = ""
+	}
+	mu.Unlock()
+	l.f.Close()
+
+	if rec != nil {
+		panic(rec)
+	}
+}
+
+// RemoteWriter returns writer which logs output of remote command line by line as it arrives, stream is stdout
+// or stderr. Close logs the last line if it does not end with newline.
+func RemoteWriter(host string, stream string) io.WriteCloser {
+	return &remoteWriter{host: host, stream: stream}
+}
+
+type remoteWriter struct {
+	host   string
+	stream string
+	buf    []byte
+}
+
+func (w *remoteWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexByte(string(w.buf), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *remoteWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *remoteWriter) line(l string) {
+	write(entry{level: Info, Host: w.host, Stream: w.stream, Msg: strings.TrimRight(l, "\r")}, true)
+}
@@ -20,22 +20,121 @@ func HomeDir() string {
 	return homeDir
 }
 
+// SetHomeDir makes CLI keep its files under dir instead of user home directory, e.g. in tests
+func SetHomeDir(dir string) {
+	homeDir = dir
+}
+
 func TscalectlDir() string {
 	return HomeDir() + "/.tscalectl"
 }
 
+// DefaultContext keeps its files directly in CLI dir, as CLI did before contexts
+const DefaultContext = "default"
+
+// Context is name of the context whose credentials, state, keys and logs are used, it is set by global flags
+var Context = DefaultContext
+
+func ContextsDir() string {
+	return TscalectlDir() + "/contexts"
+}
+
+// ContextDir is directory of files which belong to current context
+func ContextDir() string {
+	return NamedContextDir(Context)
+}
+
+func NamedContextDir(name string) string {
+	if name == DefaultContext {
+		return TscalectlDir()
+	}
+	return ContextsDir() + "/" + name
+}
+
 func AwsKeyPairsDir() string {
-	return TscalectlDir() + "/awskeypairs"
+	return ContextDir() + "/awskeypairs"
+}
+
+func ConfigFile() string {
+	return TscalectlDir() + "/config.json"
 }
 
 func CredsFile() string {
-	return TscalectlDir() + "/credentials.json"
+	return ContextDir() + "/credentials.json"
 }
 
 func StateFile() string {
-	return TscalectlDir() + "/state.json"
+	return NamedStateFile(Context)
+}
+
+func NamedStateFile(name string) string {
+	return NamedContextDir(name) + "/state.json"
+}
+
+func StateBackupsDir() string {
+	return ContextDir() + "/backups"
+}
+
+func LogsDir() string {
+	return ContextDir() + "/logs"
+}
+
+func NodeLogFile(nodeName string) string {
+	return LogsDir() + "/" + nodeName + ".log"
 }
 
 func KnownHostsFile() string {
 	return TscalectlDir() + "/known_hosts"
 }
+
+func SimulateDir() string {
+	return ContextDir() + "/simulate"
+}
+
+func SimulateCloudFile() string {
+	return SimulateDir() + "/cloud.json"
+}
+
+func SimulateHostKeyFile() string {
+	return SimulateDir() + "/ssh_host_key"
+}
+
+// lock files are kept apart from the files they guard, because guarded files are replaced on write
+
+// LocksDir has locks of files shared by all contexts
+func LocksDir() string {
+	return TscalectlDir() + "/locks"
+}
+
+// ContextLocksDir has locks of files which belong to current context
+func ContextLocksDir() string {
+	return ContextDir() + "/locks"
+}
+
+func ConfigLockFile() string {
+	return LocksDir() + "/config.lock"
+}
+
+func CredsLockFile() string {
+	return ContextLocksDir() + "/credentials.lock"
+}
+
+func StateLockFile() string {
+	return ContextLocksDir() + "/state.lock"
+}
+
+func KeyPairLockFile(keyName string) string {
+	return ContextLocksDir() + "/keypair-" + keyName + ".lock"
+}
+
+func KnownHostsLockFile() string {
+	return LocksDir() + "/known_hosts.lock"
+}
+
+func SimulateCloudLockFile() string {
+	return ContextLocksDir() + "/simulate-cloud.lock"
+}
+
+func SimulateHostKeyLockFile() string {
+	return ContextLocksDir() + "/simulate-host-key.lock"
+}
@@ -1,16 +1,26 @@
 package userinput
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
-	"github.com/pkg/errors"
-
-	"github.com/svennjegac/tailscale.node-provider/internal/aws/ec2cli"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
 )
 
-func Region(interactiveFlag bool, regionFlag string) string {
-	regions := ec2cli.Regions()
+// DefaultRegion is used when region flag is not given, it is set by context
+var DefaultRegion string
+
+func Region(ctx context.Context, p provider.Provider, interactiveFlag bool, regionFlag string) string {
+	regions := p.Regions(ctx)
+
+	if regionFlag == "" {
+		regionFlag = DefaultRegion
+	}
 
 	if len(regionFlag) > 0 {
 		for _, r := range regions {
@@ -18,38 +28,38 @@ func Region(interactiveFlag bool, regionFlag string) string {
 				return regionFlag
 			}
 		}
-		panic(errors.Errorf("user input region, provided invalid region as flag, use one of the allowed regions; "+
+		panic(tscerr.Errorf(tscerr.BadInput, "user input region, provided invalid region as flag, use one of the allowed regions; "+
 			"region-flag=%s, allowed-regions=%+v", regionFlag, regions))
 	}
 
 	if !interactiveFlag {
-		panic(errors.New("user input region, please specify region or use interactive flag"))
+		panic(tscerr.New(tscerr.BadInput, "user input region, please specify region or use interactive flag"))
 	}
 
-	fmt.Println("Allowed regions:")
+	output.Progressln("Allowed regions:")
 	for i, r := range regions {
-		fmt.Printf("%2d - %s\n", i, r)
+		output.Progressf("%2d - %s\n", i, r)
 	}
 
-	fmt.Println()
-	fmt.Println("Please enter number representing the region.")
-	fmt.Printf("region: ")
+	output.Progressln()
+	output.Progressln("Please enter number representing the region.")
+	output.Progressf("region: ")
 	var region int
 	_, err := fmt.Fscanln(os.Stdin, &region)
 	if err != nil {
-		panic(errors.Wrap(err, "user input region, failed to read user input"))
+		panic(tscerr.Wrap(tscerr.BadInput, err, "user input region, failed to read user input"))
 	}
 
 	if region < 0 || region >= len(regions) {
-		panic(errors.New("please enter one of the allowed region numbers"))
+		panic(tscerr.New(tscerr.BadInput, "please enter one of the allowed region numbers"))
 	}
 
-	fmt.Println()
+	output.Progressln()
 	return regions[region]
 }
 
-func InstanceType(interactiveFlag bool, instanceTypeFlag string, region string) string {
-	instanceTypes := ec2cli.InstanceTypesPerRegion(region)
+func InstanceType(ctx context.Context, p provider.Provider, interactiveFlag bool, instanceTypeFlag string, region string) string {
+	instanceTypes := p.InstanceTypesPerRegion(ctx, region)
 
 	if len(instanceTypeFlag) > 0 {
 		for _, it := range instanceTypes {
@@ -57,68 +67,101 @@ func InstanceType(interactiveFlag bool, instanceTypeFlag string, region string)
 				return instanceTypeFlag
 			}
 		}
-		panic(errors.Errorf("user input instance type, provided invalid instance type as flag, use one of the allowed instance types; "+
+		panic(tscerr.Errorf(tscerr.BadInput, "user input instance type, provided invalid instance type as flag, use one of the allowed instance types; "+
 			"instance-type-flag=%s, allowed-instance-types=%+v", instanceTypeFlag, instanceTypes))
 	}
 
 	if !interactiveFlag {
-		panic(errors.New("user input instance type, please specify instance type or use interactive flag"))
+		panic(tscerr.New(tscerr.BadInput, "user input instance type, please specify instance type or use interactive flag"))
 	}
 
-	fmt.Println("Allowed instance types:")
+	output.Progressln("Allowed instance types:")
 	for i, it := range instanceTypes {
-		fmt.Printf("%3d - %s\n", i, it)
+		output.Progressf("%3d - %s\n", i, it)
 	}
 
-	fmt.Println()
-	fmt.Println("Please enter number representing the instance type.")
-	fmt.Printf("instance_type: ")
+	output.Progressln()
+	output.Progressln("Please enter number representing the instance type.")
+	output.Progressf("instance_type: ")
 	var instanceType int
 	_, err := fmt.Fscanln(os.Stdin, &instanceType)
 	if err != nil {
-		panic(errors.Wrap(err, "user input instance type, failed to read user input"))
+		panic(tscerr.Wrap(tscerr.BadInput, err, "user input instance type, failed to read user input"))
 	}
 
 	if instanceType < 0 || instanceType >= len(instanceTypes) {
-		panic(errors.New("please enter one of the allowed instance type numbers"))
+		panic(tscerr.New(tscerr.BadInput, "please enter one of the allowed instance type numbers"))
 	}
 
-	fmt.Println()
+	output.Progressln()
 
 	return instanceTypes[instanceType]
 }
 
-func AMI(interactiveFlag bool, amiFlag string, region string) string {
+func AMI(ctx context.Context, p provider.Provider, interactiveFlag bool, amiFlag string, region string) string {
 	if len(amiFlag) > 0 {
 		return amiFlag
 	}
 
 	if !interactiveFlag {
-		panic(errors.New("user input AMI, please specify AMI or use interactive flag"))
+		panic(tscerr.New(tscerr.BadInput, "user input AMI, please specify AMI or use interactive flag"))
 	}
 
-	amis := ec2cli.AMIsPerRegion(region)
+	amis := p.AMIsPerRegion(ctx, region)
 
-	fmt.Println("Allowed AMIs:")
+	output.Progressln("Allowed AMIs:")
 	for i, ami := range amis {
-		fmt.Printf("%1d - %s\n", i, ami)
+		output.Progressf("%1d - %s\n", i, ami)
 	}
-	fmt.Println("(There is too many AMIs to list them all, if you want to use other AMI, specify it through the AMI flag)")
+	output.Progressln("(There is too many AMIs to list them all, if you want to use other AMI, specify it through the AMI flag)")
 
-	fmt.Println()
-	fmt.Println("Please enter number representing the AMI.")
-	fmt.Printf("ami: ")
+	output.Progressln()
+	output.Progressln("Please enter number representing the AMI.")
+	output.Progressf("ami: ")
 	var ami int
 	_, err := fmt.Fscanln(os.Stdin, &ami)
 	if err != nil {
-		panic(errors.Wrap(err, "user input AMI, failed to read user input"))
+		panic(tscerr.Wrap(tscerr.BadInput, err, "user input AMI, failed to read user input"))
 	}
 
 	if ami < 0 || ami >= len(amis) {
-		panic(errors.New("please enter one of the allowed AMI numbers"))
+		panic(tscerr.New(tscerr.BadInput, "please enter one of the allowed AMI numbers"))
 	}
 
-	fmt.Println()
+	output.Progressln()
 
 	return amis[ami]
 }
+
+// Line prints the question and returns trimmed answer, closed stdin is an empty answer
+func Line(question string) string {
+	output.Progress(question)
+
+	// stdin is read byte by byte (as fmt.Fscanln does), so nothing is buffered away from following questions
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			output.Progressln()
+			break
+		}
+		if err != nil {
+			panic(tscerr.Wrap(tscerr.BadInput, err, "user input line, failed to read user input"))
+		}
+	}
+
+	return strings.TrimSpace(string(line))
+}
+
+// Confirm asks yes/no question, anything but y or yes is no
+func Confirm(question string) bool {
+	answer := strings.ToLower(Line(question + " [y/N]: "))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,23 @@
+package context
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/context/contextcreate"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/context/contextlist"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/context/contextuse"
+)
+
+var ContextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage contexts",
+	Long: "Manage contexts. Context bundles an AWS account and a tailnet: AWS credentials source, tailscale auth source, default region, " +
+		"and its own credentials, state, SSH keys and logs. Every command uses the current context, --context chooses another one.",
+	Args: cobra.ExactArgs(0),
+}
+
+func init() {
+	ContextCmd.AddCommand(contextcreate.CreateCmd)
+	ContextCmd.AddCommand(contextlist.ListCmd)
+	ContextCmd.AddCommand(contextuse.UseCmd)
+}
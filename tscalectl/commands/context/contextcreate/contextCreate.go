@@ -0,0 +1,89 @@
+package contextcreate
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsccontext"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var regionFlag string
+var useFlag bool
+
+var CreateCmd = &cobra.Command{
+	Use:   "create [name string]",
+	Short: "Create context",
+	Long: "Create context for an AWS account and tailnet. Context keeps AWS credentials options given as global flags (--aws-creds, --aws-profile, " +
+		"--assume-role-arn), tailscale auth source (--tailscale-auth-key-env) and default region of up (--region). " +
+		"Its credentials, state, SSH keys and logs are kept in ~/.tscalectl/contexts/[name], credentials are asked for when the context is used first time. " +
+		"json and yaml outputs have fields name and dir.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		name := args[0]
+		tsccontext.MustBeValidName(name)
+
+		// only flags given on command line belong to the new context, others may come from current context
+		c := config.Context{Region: regionFlag}
+		if cmd.Flags().Changed("aws-creds") {
+			c.AWS.Creds = creds.AWSSource
+		}
+		if cmd.Flags().Changed("aws-profile") {
+			c.AWS.Profile = creds.AWSProfile
+		}
+		if cmd.Flags().Changed("assume-role-arn") {
+			c.AWS.AssumeRoleARN = creds.AssumeRoleARN
+		}
+		if cmd.Flags().Changed("tailscale-auth-key-env") {
+			c.TailscaleAuthKeyEnv = creds.TailscaleAuthKeyEnv
+		}
+
+		config.Update(func(cfg *config.Config) {
+			if _, ok := cfg.Context(name); ok {
+				panic(tscerr.Errorf(tscerr.BadInput, "context create, context already exists; context=%s", name))
+			}
+			if cfg.Contexts == nil {
+				cfg.Contexts = map[string]config.Context{}
+			}
+			cfg.Contexts[name] = c
+			if useFlag {
+				cfg.CurrentContext = name
+			}
+		})
+
+		dir := tscos.NamedContextDir(name)
+		fileutil.MkdirAll(dir)
+
+		if output.Structured() {
+			output.Result(result{Name: name, Dir: dir})
+			return nil
+		}
+
+		fmt.Printf("Context %s created.\n", name)
+		if useFlag {
+			fmt.Printf("Switched to context %s.\n", name)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	CreateCmd.Flags().StringVarP(&regionFlag, "region", "r", "", "Default region of up in this context (AWS region, e.g. eu-west-1)")
+	CreateCmd.Flags().BoolVar(&useFlag, "use", false, "Switch to the new context")
+}
+
+// result is the json and yaml output of context create command
+type result struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
@@ -0,0 +1,88 @@
+package contextlist
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var ListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List contexts",
+	Long: "List contexts, the one used by commands is marked. json and yaml outputs are lists of contexts with fields name, current, " +
+		"aws_creds, aws_profile, assume_role_arn, tailscale_auth_key_env, region and dir.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		cfg := config.Load()
+
+		contexts := make([]Context, 0, len(cfg.Contexts)+1)
+		for _, name := range cfg.ContextNames() {
+			c := cfg.MustContext(name)
+			awsCreds := c.AWS.Creds
+			if awsCreds == "" {
+				awsCreds = "file"
+			}
+			contexts = append(contexts, Context{
+				Name:                name,
+				Current:             name == tscos.Context,
+				AWSCreds:            awsCreds,
+				AWSProfile:          c.AWS.Profile,
+				AssumeRoleARN:       c.AWS.AssumeRoleARN,
+				TailscaleAuthKeyEnv: c.TailscaleAuthKeyEnv,
+				Region:              c.Region,
+				Dir:                 tscos.NamedContextDir(name),
+			})
+		}
+
+		if output.Structured() {
+			output.Result(contexts)
+			return nil
+		}
+
+		headers := []string{"CURRENT", "NAME", "AWS CREDS", "AWS PROFILE", "ASSUME ROLE", "TAILSCALE AUTH KEY", "REGION"}
+		if output.Format == output.Wide {
+			headers = append(headers, "DIR")
+		}
+
+		rows := make([][]string, 0, len(contexts))
+		for _, c := range contexts {
+			current := ""
+			if c.Current {
+				current = "*"
+			}
+			tailscaleAuthKey := "file"
+			if c.TailscaleAuthKeyEnv != "" {
+				tailscaleAuthKey = fmt.Sprintf("env %s", c.TailscaleAuthKeyEnv)
+			}
+
+			row := []string{current, c.Name, c.AWSCreds, c.AWSProfile, c.AssumeRoleARN, tailscaleAuthKey, c.Region}
+			if output.Format == output.Wide {
+				row = append(row, c.Dir)
+			}
+			rows = append(rows, row)
+		}
+
+		output.PrintTable(headers, rows)
+
+		return nil
+	},
+}
+
+// Context is the json and yaml output of context list command
+type Context struct {
+	Name                string `json:"name" yaml:"name"`
+	Current             bool   `json:"current" yaml:"current"`
+	AWSCreds            string `json:"aws_creds" yaml:"aws_creds"`
+	AWSProfile          string `json:"aws_profile,omitempty" yaml:"aws_profile,omitempty"`
+	AssumeRoleARN       string `json:"assume_role_arn,omitempty" yaml:"assume_role_arn,omitempty"`
+	TailscaleAuthKeyEnv string `json:"tailscale_auth_key_env,omitempty" yaml:"tailscale_auth_key_env,omitempty"`
+	Region              string `json:"region,omitempty" yaml:"region,omitempty"`
+	Dir                 string `json:"dir" yaml:"dir"`
+}
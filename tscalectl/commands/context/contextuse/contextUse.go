@@ -0,0 +1,40 @@
+package contextuse
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+var UseCmd = &cobra.Command{
+	Use:   "use [name string]",
+	Short: "Switch current context",
+	Long: "Switch current context, commands use it when --context is not given. Context default keeps files directly in ~/.tscalectl. " +
+		"json and yaml outputs have field current_context.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		name := args[0]
+		config.Update(func(cfg *config.Config) {
+			cfg.MustContext(name)
+			cfg.CurrentContext = name
+		})
+
+		tsclog.Infof("Switched to context %s.", name)
+
+		if output.Structured() {
+			output.Result(result{CurrentContext: name})
+		}
+
+		return nil
+	},
+}
+
+// result is the json and yaml output of context use command
+type result struct {
+	CurrentContext string `json:"current_context"`
+}
@@ -3,7 +3,14 @@ package creds
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsdecrypt"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsdelete"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsencrypt"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsrotate"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsset"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsshow"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsunlock"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds/credsvalidate"
 )
 
 var CredsCmd = &cobra.Command{
@@ -14,5 +21,12 @@ var CredsCmd = &cobra.Command{
 }
 
 func init() {
+	CredsCmd.AddCommand(credsdecrypt.DecryptCmd)
 	CredsCmd.AddCommand(credsdelete.DeleteCmd)
+	CredsCmd.AddCommand(credsencrypt.EncryptCmd)
+	CredsCmd.AddCommand(credsrotate.RotateCmd)
+	CredsCmd.AddCommand(credsset.SetCmd)
+	CredsCmd.AddCommand(credsshow.ShowCmd)
+	CredsCmd.AddCommand(credsunlock.UnlockCmd)
+	CredsCmd.AddCommand(credsvalidate.ValidateCmd)
 }
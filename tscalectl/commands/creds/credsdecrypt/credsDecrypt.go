@@ -0,0 +1,43 @@
+package credsdecrypt
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var DecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Store credentials in plain text",
+	Long: "Decrypt credentials file and private SSH keys of the current context, they are stored in plain text (readable only by you) again. " +
+		"json and yaml outputs have fields file and decrypted_ssh_keys (number of decrypted private SSH keys).",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		creds.Decrypt()
+		tsclog.Infof("Credentials in %s are decrypted.", tscos.CredsFile())
+
+		res := result{File: tscos.CredsFile(), DecryptedSSHKeys: sshutil.SealPrivateKeys(nil)}
+		if res.DecryptedSSHKeys > 0 {
+			tsclog.Infof("%d private SSH keys in %s are decrypted.", res.DecryptedSSHKeys, tscos.AwsKeyPairsDir())
+		}
+
+		if output.Structured() {
+			output.Result(res)
+		}
+
+		return nil
+	},
+}
+
+// result is the json and yaml output of creds decrypt command
+type result struct {
+	File             string `json:"file"`
+	DecryptedSSHKeys int    `json:"decrypted_ssh_keys"`
+}
@@ -4,21 +4,34 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
 	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
 	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
 )
 
 var DeleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete credentials",
-	Long:  "Delete credentials. This will cause you to be prompted for new credentials on e.g. UP command. (If you dont create credentials before)",
-	Args:  cobra.ExactArgs(0),
+	Long: "Delete credentials of the current context. This will cause you to be prompted for new credentials on e.g. UP command. (If you dont set them with creds set before) " +
+		"json and yaml outputs have field deleted (the deleted file).",
+	Args: cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 		defer trycatch.ToError(&runErr)
 
 		fileutil.MkdirAllFromFile(tscos.CredsFile())
 		fileutil.Remove(tscos.CredsFile())
+		tsclog.Infof("Credentials in %s are deleted.", tscos.CredsFile())
+
+		if output.Structured() {
+			output.Result(result{Deleted: tscos.CredsFile()})
+		}
 
 		return nil
 	},
 }
+
+// result is the json and yaml output of creds delete command
+type result struct {
+	Deleted string `json:"deleted"`
+}
@@ -0,0 +1,58 @@
+package credsencrypt
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var sshKeysFlag bool
+
+var EncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt credentials with a passphrase",
+	Long: "Encrypt credentials file of the current context with a passphrase (scrypt derived key, XChaCha20-Poly1305). " +
+		"Encrypted file gets the new passphrase. New passphrase is read from " + creds.PassphraseEnv + " if it is set. " +
+		"Commands ask for the passphrase, unlock it for the shell session with eval \"$(tscalectl creds unlock)\". " +
+		"json and yaml outputs have fields file and encrypted_ssh_keys (number of encrypted private SSH keys).",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		// current passphrase is asked for before the new one
+		if creds.Encrypted() {
+			creds.Unlock()
+		}
+		passphrase := creds.PromptNewPassphrase()
+
+		key, sshKeys := creds.Encrypt(passphrase, sshKeysFlag)
+		tsclog.Infof("Credentials in %s are encrypted.", tscos.CredsFile())
+
+		res := result{File: tscos.CredsFile()}
+		if sshKeys {
+			res.EncryptedSSHKeys = sshutil.SealPrivateKeys(&key)
+			tsclog.Infof("%d private SSH keys in %s are encrypted, new keys will be encrypted too.", res.EncryptedSSHKeys, tscos.AwsKeyPairsDir())
+		}
+
+		if output.Structured() {
+			output.Result(res)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	EncryptCmd.Flags().BoolVar(&sshKeysFlag, "ssh-keys", false, "Encrypt private SSH keys of nodes too, ssh can't use them until creds decrypt")
+}
+
+// result is the json and yaml output of creds encrypt command
+type result struct {
+	File             string `json:"file"`
+	EncryptedSSHKeys int    `json:"encrypted_ssh_keys"`
+}
@@ -0,0 +1,78 @@
+package credsrotate
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// secrets which can be rotated
+const (
+	awsAccessKey     = "aws-access-key"
+	tailscaleAuthKey = "tailscale-auth-key"
+)
+
+var RotateCmd = &cobra.Command{
+	Use:   "rotate [aws-access-key|tailscale-auth-key]",
+	Short: "Replace one secret",
+	Long: "Replace one secret in credentials file of the current context, other credentials are kept. " +
+		"aws-access-key asks for access key ID, secret access key and optional session token, which always change together. " +
+		"json and yaml outputs have fields file and rotated (the rotated secret).",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{awsAccessKey, tailscaleAuthKey},
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		secret := args[0]
+
+		if _, ok := creds.Load(); !ok {
+			panic(tscerr.Errorf(tscerr.NotFound, "creds rotate, credentials are not set up, run creds set; file=%s", tscos.CredsFile()))
+		}
+
+		// values are asked for before credentials file is locked, other commands don't wait for typing
+		var input creds.Creds
+		switch secret {
+		case awsAccessKey:
+			creds.PromptAWSKeys(&input, true)
+		case tailscaleAuthKey:
+			creds.PromptTailscaleAuthKey(&input)
+		}
+
+		creds.Update(func(c *creds.Creds) {
+			switch secret {
+			case awsAccessKey:
+				c.AwsAccessKeyID = input.AwsAccessKeyID
+				c.AwsSecretAccessKey = input.AwsSecretAccessKey
+				c.AwsSessionToken = input.AwsSessionToken
+			case tailscaleAuthKey:
+				c.TailscaleAuthKey = input.TailscaleAuthKey
+			}
+		})
+
+		if secret == awsAccessKey && creds.AWSSource != creds.FileSource {
+			tsclog.Warnf("AWS credentials come from %s source, AWS keys in credentials file are used only with --aws-creds=%s", creds.AWSSource, creds.FileSource)
+		}
+		if secret == tailscaleAuthKey && creds.TailscaleAuthKeyEnv != "" {
+			tsclog.Warnf("Tailscale auth key comes from environment variable %s, key in credentials file is not used", creds.TailscaleAuthKeyEnv)
+		}
+
+		tsclog.Infof("Rotated %s, check it with tscalectl creds validate.", secret)
+
+		if output.Structured() {
+			output.Result(result{File: tscos.CredsFile(), Rotated: secret})
+		}
+
+		return nil
+	},
+}
+
+// result is the json and yaml output of creds rotate command
+type result struct {
+	File    string `json:"file"`
+	Rotated string `json:"rotated"`
+}
@@ -0,0 +1,77 @@
+package credsset
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var SetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set credentials",
+	Long: "Set credentials of the current context, typed secrets are not shown. AWS keys are asked for only with --aws-creds=file " +
+		"(session token is optional, it is set with temporary keys), tailscale auth key only if --tailscale-auth-key-env is not used. " +
+		"json and yaml outputs have fields file and saved (names of the saved credentials).",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		awsKeys := creds.AWSSource == creds.FileSource
+		tailscaleAuthKey := creds.TailscaleAuthKeyEnv == ""
+		if !awsKeys && !tailscaleAuthKey {
+			panic(tscerr.Errorf(tscerr.BadInput, "creds set, nothing to set, AWS credentials come from %s source and tailscale auth key from environment variable %s", creds.AWSSource, creds.TailscaleAuthKeyEnv))
+		}
+
+		// passphrase of encrypted file is asked for before the values
+		if creds.Encrypted() {
+			creds.Unlock()
+		}
+
+		// values are asked for before credentials file is locked, other commands don't wait for typing
+		var input creds.Creds
+		if awsKeys {
+			creds.PromptAWSKeys(&input, true)
+		}
+		if tailscaleAuthKey {
+			creds.PromptTailscaleAuthKey(&input)
+		}
+
+		// values which were not asked for are kept
+		creds.Update(func(c *creds.Creds) {
+			if awsKeys {
+				c.AwsAccessKeyID = input.AwsAccessKeyID
+				c.AwsSecretAccessKey = input.AwsSecretAccessKey
+				c.AwsSessionToken = input.AwsSessionToken
+			}
+			if tailscaleAuthKey {
+				c.TailscaleAuthKey = input.TailscaleAuthKey
+			}
+		})
+
+		tsclog.Infof("Credentials saved to %s, check them with tscalectl creds validate.", tscos.CredsFile())
+
+		if output.Structured() {
+			res := result{File: tscos.CredsFile(), Saved: []string{}}
+			if awsKeys {
+				res.Saved = append(res.Saved, "aws_access_key_id", "aws_secret_access_key", "aws_session_token")
+			}
+			if tailscaleAuthKey {
+				res.Saved = append(res.Saved, "tailscale_auth_key")
+			}
+			output.Result(res)
+		}
+
+		return nil
+	},
+}
+
+// result is the json and yaml output of creds set command
+type result struct {
+	File  string   `json:"file"`
+	Saved []string `json:"saved"`
+}
@@ -0,0 +1,95 @@
+package credsshow
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var ShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show credentials",
+	Long: "Show credentials of the current context with masked values and where they come from. With --aws-creds=chain AWS credentials " +
+		"are resolved (which may refresh SSO or assumed role credentials). json and yaml outputs are lists of credentials with fields " +
+		"name, value (masked), source and expires.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		fileCreds, _ := creds.Load()
+		fileSource := "file " + tscos.CredsFile()
+		if creds.Encrypted() {
+			fileSource += " (encrypted)"
+		}
+
+		var shown []credential
+		if creds.AWSSource == creds.FileSource && creds.AssumeRoleARN == "" {
+			shown = append(shown,
+				credential{Name: "aws_access_key_id", Value: creds.Mask(fileCreds.AwsAccessKeyID), Source: fileSource},
+				credential{Name: "aws_secret_access_key", Value: creds.Mask(fileCreds.AwsSecretAccessKey), Source: fileSource},
+				credential{Name: "aws_session_token", Value: creds.Mask(fileCreds.AwsSessionToken), Source: fileSource},
+			)
+		} else {
+			// credentials are shown as AWS clients get them
+			c := creds.RetrieveAWSWithoutPrompt(ctx)
+			source := creds.AWSSource + " " + c.Source
+			if creds.AWSProfile != "" {
+				source += ", profile " + creds.AWSProfile
+			}
+			if creds.AssumeRoleARN != "" {
+				source += ", assumed role " + creds.AssumeRoleARN
+			}
+			var expires *time.Time
+			if c.CanExpire {
+				expires = &c.Expires
+			}
+			shown = append(shown,
+				credential{Name: "aws_access_key_id", Value: creds.Mask(c.AccessKeyID), Source: source, Expires: expires},
+				credential{Name: "aws_secret_access_key", Value: creds.Mask(c.SecretAccessKey), Source: source, Expires: expires},
+				credential{Name: "aws_session_token", Value: creds.Mask(c.SessionToken), Source: source, Expires: expires},
+			)
+		}
+
+		if creds.TailscaleAuthKeyEnv == "" {
+			shown = append(shown, credential{Name: "tailscale_auth_key", Value: creds.Mask(fileCreds.TailscaleAuthKey), Source: fileSource})
+		} else {
+			shown = append(shown, credential{Name: "tailscale_auth_key", Value: creds.Mask(creds.TailscaleAuthKey()), Source: "env " + creds.TailscaleAuthKeyEnv})
+		}
+
+		if output.Structured() {
+			output.Result(shown)
+			return nil
+		}
+
+		rows := make([][]string, 0, len(shown))
+		for _, c := range shown {
+			value := c.Value
+			if value == "" {
+				value = "(not set)"
+			}
+			expires := ""
+			if c.Expires != nil {
+				expires = c.Expires.Local().Format(time.RFC3339)
+			}
+			rows = append(rows, []string{c.Name, value, c.Source, expires})
+		}
+		output.PrintTable([]string{"NAME", "VALUE", "SOURCE", "EXPIRES"}, rows)
+
+		return nil
+	},
+}
+
+// credential is the json and yaml output of creds show command
+type credential struct {
+	Name    string     `json:"name" yaml:"name"`
+	Value   string     `json:"value" yaml:"value"`
+	Source  string     `json:"source" yaml:"source"`
+	Expires *time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+}
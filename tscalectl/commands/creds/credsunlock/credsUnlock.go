@@ -0,0 +1,39 @@
+package credsunlock
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+)
+
+var UnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Unlock encrypted credentials for the shell session",
+	Long: "Ask for passphrase of encrypted credentials and print shell command which keeps the key in " + creds.SessionEnv + ", " +
+		"run it as eval \"$(tscalectl creds unlock)\". Commands of the shell session don't ask for the passphrase then, " +
+		"unset " + creds.SessionEnv + " locks credentials again. json and yaml outputs have fields env (variable name) and session (its value).",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		key := creds.Unlock()
+		if output.Structured() {
+			output.Result(result{Env: creds.SessionEnv, Session: key.Session()})
+			return nil
+		}
+		// shell command is the text result, eval runs it
+		fmt.Printf("export %s=%s\n", creds.SessionEnv, key.Session())
+
+		return nil
+	},
+}
+
+// result is the json and yaml output of creds unlock command
+type result struct {
+	Env     string `json:"env"`
+	Session string `json:"session"`
+}
@@ -0,0 +1,161 @@
+package credsvalidate
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/aws/ec2cli"
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/tailnet"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+// results of a check
+const (
+	ok      = "ok"
+	warn    = "warn"
+	failed  = "failed"
+	skipped = "skipped"
+)
+
+// auth keys which expire sooner are reported with a warning
+const expiryWarning = time.Hour * 24 * 7
+
+var ValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check credentials",
+	Long: "Check credentials of the current context: AWS credentials are resolved and used for a harmless EC2 call (DescribeRegions), " +
+		"tailscale auth key format is checked, and its expiry too if " + tailnet.APIKeyEnv + " holds a tailscale API access token of the same tailnet. " +
+		"Command fails if any check fails. json and yaml outputs are lists of checks with fields name, result (ok, warn, failed or skipped) and detail.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		// validate never asks for credentials, missing ones are a failed check
+		fileCreds, fileOK := creds.Load()
+		if !fileOK && (creds.AWSSource == creds.FileSource || creds.TailscaleAuthKeyEnv == "") {
+			panic(tscerr.Errorf(tscerr.NotFound, "creds validate, credentials are not set up, run creds set; file=%s", tscos.CredsFile()))
+		}
+
+		var checks []check
+
+		awsCheck := run("aws credentials", func() (string, string) {
+			c := creds.RetrieveAWSWithoutPrompt(ctx)
+			return ok, fmt.Sprintf("access key %s from %s", creds.Mask(c.AccessKeyID), c.Source)
+		})
+		checks = append(checks, awsCheck)
+
+		if awsCheck.Result == ok {
+			checks = append(checks, run("ec2 api", func() (string, string) {
+				return ok, fmt.Sprintf("DescribeRegions returned %d regions", len(ec2cli.Regions(ctx)))
+			}))
+		} else {
+			checks = append(checks, check{Name: "ec2 api", Result: skipped, Detail: "no AWS credentials"})
+		}
+
+		key := fileCreds.TailscaleAuthKey
+		if creds.TailscaleAuthKeyEnv != "" {
+			key = os.Getenv(creds.TailscaleAuthKeyEnv)
+		}
+
+		formatCheck := run("tailscale auth key format", func() (string, string) {
+			if key == "" {
+				panic(tscerr.New(tscerr.Auth, "tailscale auth key is not set"))
+			}
+			creds.MustBeValidTailscaleAuthKey(key)
+			return ok, creds.Mask(key)
+		})
+		checks = append(checks, formatCheck)
+
+		checks = append(checks, run("tailscale auth key expiry", func() (string, string) {
+			if formatCheck.Result != ok {
+				return skipped, "invalid auth key"
+			}
+			id, hasID := creds.TailscaleAuthKeyID(key)
+			if !hasID {
+				return skipped, "key has no ID (OAuth client secret or key of old format)"
+			}
+			apiKey := os.Getenv(tailnet.APIKeyEnv)
+			if apiKey == "" {
+				return skipped, "set " + tailnet.APIKeyEnv + " to check it"
+			}
+
+			authKey := tailnet.GetAuthKey(ctx, apiKey, id)
+			left := time.Until(authKey.Expires)
+			switch {
+			case authKey.Invalid || left <= 0:
+				return failed, fmt.Sprintf("key %s is revoked or expired (expires %s)", id, authKey.Expires.Local().Format(time.RFC3339))
+			case left < expiryWarning:
+				return warn, fmt.Sprintf("key %s expires in %s", id, left.Round(time.Hour))
+			default:
+				return ok, fmt.Sprintf("key %s expires %s", id, authKey.Expires.Local().Format(time.RFC3339))
+			}
+		}))
+
+		printChecks(checks)
+
+		// exit code is given by the first failure, e.g. network failure is not an auth failure
+		failures := 0
+		kind := tscerr.Auth
+		for _, c := range checks {
+			if c.Result != failed {
+				continue
+			}
+			if failures == 0 && c.err != nil {
+				kind = tscerr.KindOf(c.err)
+			}
+			failures++
+		}
+		if failures > 0 {
+			panic(tscerr.Errorf(kind, "creds validate, %d of %d checks failed", failures, len(checks)))
+		}
+
+		return nil
+	},
+}
+
+// check is the json and yaml output of creds validate command
+type check struct {
+	Name   string `json:"name" yaml:"name"`
+	Result string `json:"result" yaml:"result"`
+	Detail string `json:"detail" yaml:"detail"`
+
+	err error
+}
+
+// run runs check, its panic is a failed check
+func run(name string, fn func() (result string, detail string)) (c check) {
+	c.Name = name
+
+	var err error
+	func() {
+		defer trycatch.ToError(&err)
+		c.Result, c.Detail = fn()
+	}()
+	if err != nil {
+		c.Result, c.Detail, c.err = failed, err.Error(), err
+	}
+
+	return c
+}
+
+func printChecks(checks []check) {
+	if output.Structured() {
+		output.Result(checks)
+		return
+	}
+
+	rows := make([][]string, 0, len(checks))
+	for _, c := range checks {
+		rows = append(rows, []string{c.Name, c.Result, c.Detail})
+	}
+	output.PrintTable([]string{"CHECK", "RESULT", "DETAIL"}, rows)
+}
@@ -1,47 +1,114 @@
 package down
 
 import (
-	"fmt"
 	"strconv"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/svennjegac/tailscale.node-provider/internal/aws/ec2cli"
+	"github.com/svennjegac/tailscale.node-provider/internal/inventory"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
 	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
 	"github.com/svennjegac/tailscale.node-provider/internal/state"
 	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
 )
 
 var DownCmd = &cobra.Command{
 	Use:   "down [nodeID string]",
 	Short: "Terminate tailscale node and remove associated resources",
-	Long:  "Terminate tailscale node and remove associated resources. (Remove it from state file, remove SSH keys, delete AWS instance, security group and key pairs)",
-	Args:  cobra.ExactArgs(1),
+	Long: "Terminate tailscale node and remove associated resources. (Remove it from state file, remove SSH keys, delete AWS instance, security group and key pairs) " +
+		"json and yaml outputs have fields id, name and deleted, which lists deleted resources in order of deletion with fields type " +
+		"(instance, security_group, key_pair, local_ssh_keys or state_node) and id.",
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 		defer trycatch.ToError(&runErr)
 
+		ctx := cmd.Context()
+
 		tscalectlID, err := strconv.Atoi(args[0])
 		if err != nil {
-			panic(errors.Wrap(err, "provide integer ID for node ID (first 3 numbers of your node name)"))
+			panic(tscerr.Wrap(tscerr.BadInput, err, "provide integer ID for node ID (first 3 numbers of your node name)"))
+		}
+
+		node := state.GetNode(ctx, tscalectlID)
+		p := provider.New(node.Provider)
+
+		nodeLog := tsclog.OpenNode(node.TscalectlName, false)
+		defer nodeLog.Close()
+		tsclog.Infof("Deleting %s", node.TscalectlName)
+
+		// node marked for cleanup by state refresh is dead, only its leftovers are deleted
+		if node.MarkedForCleanup {
+			c := inventory.CheckNode(node, p.ManagedResources(ctx, node.Region))
+			if c.Dead() {
+				node.InstanceID, node.SecurityGroupID, node.KeyPairID = "", "", ""
+				if c.SecurityGroup != nil {
+					node.SecurityGroupID = c.SecurityGroup.ID
+				}
+				if c.KeyPair != nil {
+					node.KeyPairID = c.KeyPair.ID
+				}
+			}
+		}
+
+		// nodes created by older tscalectl versions don't have resource IDs in state, find them by name
+		if node.InstanceID == "" && !node.MarkedForCleanup {
+			if inst, ok := p.FindInstance(ctx, node.Region, node.TscalectlName); ok {
+				node.InstanceID = inst.ID
+			}
+		}
+		if node.SecurityGroupID == "" && !node.MarkedForCleanup {
+			node.SecurityGroupID, _ = p.FindSecurityGroup(ctx, node.Region, node.TscalectlName)
+		}
+		if node.KeyPairID == "" && !node.MarkedForCleanup {
+			node.KeyPairID, _ = p.FindKeyPair(ctx, node.Region, node.TscalectlName)
 		}
 
-		node := state.GetNode(tscalectlID)
+		res := result{ID: node.TscalectlID, Name: node.TscalectlName, Deleted: []deletedResource{}}
 
-		ec2cli.TerminateInstance(node.Region, node.TscalectlName)
-		fmt.Println("Deleted EC2 instance")
-		ec2cli.WaitForInstanceToTerminate(node.Region, node.TscalectlName)
-		ec2cli.DeleteSecurityGroup(node.Region, node.TscalectlName)
-		fmt.Println("Deleted EC2 security group")
-		ec2cli.DeleteKeyPair(node.Region, node.TscalectlName)
-		fmt.Println("Deleted EC2 key pair")
+		if node.InstanceID != "" {
+			p.TerminateInstance(ctx, node.Region, node.InstanceID)
+			tsclog.Infof("Deleted EC2 instance")
+			p.WaitForInstanceToTerminate(ctx, node.Region, node.InstanceID)
+			res.Deleted = append(res.Deleted, deletedResource{Type: "instance", ID: node.InstanceID})
+		}
+		if node.SecurityGroupID != "" {
+			p.DeleteSecurityGroup(ctx, node.Region, node.SecurityGroupID)
+			tsclog.Infof("Deleted EC2 security group")
+			res.Deleted = append(res.Deleted, deletedResource{Type: "security_group", ID: node.SecurityGroupID})
+		}
+		if node.KeyPairID != "" {
+			p.DeleteKeyPair(ctx, node.Region, node.KeyPairID)
+			tsclog.Infof("Deleted EC2 key pair")
+			res.Deleted = append(res.Deleted, deletedResource{Type: "key_pair", ID: node.KeyPairID})
+		}
 
 		sshutil.DeleteKeyPair(node.TscalectlName)
-		fmt.Println("Deleted CLI local SSH keys")
+		tsclog.Infof("Deleted CLI local SSH keys")
+		res.Deleted = append(res.Deleted, deletedResource{Type: "local_ssh_keys", ID: node.TscalectlName})
 
-		state.RemoveNode(tscalectlID)
-		fmt.Println("Deleted node from CLI local state")
+		state.RemoveNode(ctx, tscalectlID)
+		tsclog.Infof("Deleted node from CLI local state")
+		res.Deleted = append(res.Deleted, deletedResource{Type: "state_node", ID: strconv.Itoa(tscalectlID)})
+
+		if output.Structured() {
+			output.Result(res)
+		}
 
 		return nil
 	},
 }
+
+// result is the json and yaml output of down command
+type result struct {
+	ID      int               `json:"id"`
+	Name    string            `json:"name"`
+	Deleted []deletedResource `json:"deleted"`
+}
+
+type deletedResource struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
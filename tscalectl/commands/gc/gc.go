@@ -0,0 +1,263 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/inventory"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/userinput"
+)
+
+var providerFlag string
+var regionFlag string
+var olderThanFlag time.Duration
+var yesFlag bool
+
+var GCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete tscalectl resources which are not in state",
+	Long: "List every tscalectl managed instance, security group and key pair which no node in state references " +
+		"(e.g. leftovers of failed `up` or interrupted `down`) and delete them after confirmation. " +
+		"Resources referenced by state of another context are not touched, contexts can share AWS account. " +
+		"Nodes marked for cleanup by `state refresh --mark` are deleted with their leftovers too, regardless of --older-than. " +
+		"JSON and YAML output has `resources` (region, type, id, name, created_at), `deleted` and `unknown_age` count of skipped orphans.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		p := provider.New(providerFlag)
+
+		regionNames := p.Regions(ctx)
+		if regionFlag != "" {
+			regionNames = []string{regionFlag}
+		}
+		regions := make([]inventory.Region, 0, len(regionNames))
+		for _, r := range regionNames {
+			regions = append(regions, inventory.Region{Provider: providerFlag, Region: r})
+		}
+
+		s := state.GetState(ctx)
+		states := inventory.States(s)
+		results := inventory.Scan(ctx, regions)
+
+		var orphans []inventory.Group
+		unknownAge := 0
+		for _, r := range regions {
+			res := results[r]
+			if res.Err != nil {
+				tsclog.Warnf("Region %s was not scanned: %s", r.Region, res.Err)
+				continue
+			}
+
+			for _, g := range inventory.Untracked(states, r, res.Resources) {
+				if olderThanFlag > 0 {
+					createdAt := groupCreatedAt(g)
+					if createdAt.IsZero() {
+						unknownAge++
+						continue
+					}
+					if time.Since(createdAt) < olderThanFlag {
+						continue
+					}
+				}
+				orphans = append(orphans, g)
+			}
+			orphans = append(orphans, inventory.Marked(s, r, res.Resources)...)
+		}
+
+		res := result{Resources: []resource{}, UnknownAge: unknownAge}
+		for _, g := range orphans {
+			res.Resources = append(res.Resources, groupResources(g)...)
+		}
+
+		if unknownAge > 0 {
+			output.Progressf("%d orphan(s) of unknown age were skipped because of --older-than.\n", unknownAge)
+		}
+		if len(orphans) == 0 {
+			output.Progressln("There are no orphaned resources.")
+			printResult(res)
+			return nil
+		}
+
+		if !output.Structured() {
+			rows := make([][]string, 0, len(res.Resources))
+			for _, r := range res.Resources {
+				rows = append(rows, []string{r.Region, r.Type, r.ID, r.Name, age(r.CreatedAt)})
+			}
+			output.PrintTable([]string{"REGION", "RESOURCE", "ID", "NAME", "AGE"}, rows)
+			output.Progressln()
+		}
+
+		if !yesFlag && !userinput.Confirm(fmt.Sprintf("Delete %d orphaned resource set(s)?", len(orphans))) {
+			output.Progressln("Nothing was deleted.")
+			printResult(res)
+			return nil
+		}
+
+		failed := 0
+		for _, g := range orphans {
+			if err := deleteGroup(ctx, p, s, g); err != nil {
+				failed++
+				tsclog.Warnf("Failed to delete resources of %s in %s: %s", g.Name, g.Region.Region, err)
+				continue
+			}
+			tsclog.Infof("Deleted resources of %s in %s", g.Name, g.Region.Region)
+		}
+
+		if failed > 0 {
+			panic(errors.Errorf("gc, resources of %d node name(s) were not deleted", failed))
+		}
+
+		res.Deleted = true
+		printResult(res)
+		return nil
+	},
+}
+
+func init() {
+	GCCmd.Flags().StringVar(&providerFlag, "provider", provider.AWS, "Cloud which is scanned (aws or simulate)")
+	GCCmd.Flags().StringVarP(&regionFlag, "region", "r", "", "Scan only this region (default is all regions)")
+	GCCmd.Flags().DurationVar(&olderThanFlag, "older-than", 0, "Touch only resources older than this (e.g. 24h)")
+	GCCmd.Flags().BoolVarP(&yesFlag, "yes", "y", false, "Delete without confirmation")
+}
+
+// result is the json and yaml output of gc command
+type result struct {
+	Resources  []resource `json:"resources"`
+	Deleted    bool       `json:"deleted"`
+	UnknownAge int        `json:"unknown_age"`
+}
+
+type resource struct {
+	Region string `json:"region"`
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	// CreatedAt is nil if it is unknown (e.g. security group created by older tscalectl version)
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+func printResult(res result) {
+	if output.Structured() {
+		output.Result(res)
+	}
+}
+
+func groupResources(g inventory.Group) []resource {
+	var resources []resource
+	if g.Instance != nil {
+		resources = append(resources, resource{Region: g.Region.Region, Type: "instance (" + g.Instance.State + ")", ID: g.Instance.ID, Name: g.Name, CreatedAt: timePtr(g.Instance.LaunchedAt)})
+	}
+	if g.SecurityGroup != nil {
+		resources = append(resources, resource{Region: g.Region.Region, Type: "security group", ID: g.SecurityGroup.ID, Name: g.Name, CreatedAt: timePtr(g.SecurityGroup.CreatedAt)})
+	}
+	if g.KeyPair != nil {
+		resources = append(resources, resource{Region: g.Region.Region, Type: "key pair", ID: g.KeyPair.ID, Name: g.Name, CreatedAt: timePtr(g.KeyPair.CreatedAt)})
+	}
+	if g.Node != nil {
+		resources = append(resources, resource{Region: g.Region.Region, Type: "state node (marked for cleanup)", ID: strconv.Itoa(g.Node.TscalectlID), Name: g.Name, CreatedAt: timePtr(g.Node.CreatedAt)})
+	}
+	return resources
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// deleteGroup deletes resources in the same order as the down command
+func deleteGroup(ctx context.Context, p provider.Provider, s *state.State, g inventory.Group) (err error) {
+	defer trycatch.ToError(&err)
+
+	region := g.Region.Region
+	if g.Instance != nil {
+		p.TerminateInstance(ctx, region, g.Instance.ID)
+		p.WaitForInstanceToTerminate(ctx, region, g.Instance.ID)
+	}
+	if g.SecurityGroup != nil {
+		p.DeleteSecurityGroup(ctx, region, g.SecurityGroup.ID)
+	}
+	if g.KeyPair != nil {
+		p.DeleteKeyPair(ctx, region, g.KeyPair.ID)
+	}
+
+	if g.Node != nil {
+		sshutil.DeleteKeyPair(g.Name)
+		state.RemoveNode(ctx, g.Node.TscalectlID)
+		return nil
+	}
+
+	// local keys of the same name can belong to node in state (e.g. created after its orphan)
+	for _, node := range s.Nodes {
+		if node.TscalectlName == g.Name {
+			return nil
+		}
+	}
+	sshutil.DeleteKeyPair(g.Name)
+
+	return nil
+}
+
+// groupCreatedAt is creation time of the oldest resource, AWS security groups created by older tscalectl
+// versions don't have creation time, but they were created together with their key pair
+func groupCreatedAt(g inventory.Group) time.Time {
+	var createdAt time.Time
+	for _, t := range []time.Time{instanceLaunchedAt(g), securityGroupCreatedAt(g), keyPairCreatedAt(g)} {
+		if !t.IsZero() && (createdAt.IsZero() || t.Before(createdAt)) {
+			createdAt = t
+		}
+	}
+	return createdAt
+}
+
+func instanceLaunchedAt(g inventory.Group) time.Time {
+	if g.Instance == nil {
+		return time.Time{}
+	}
+	return g.Instance.LaunchedAt
+}
+
+func securityGroupCreatedAt(g inventory.Group) time.Time {
+	if g.SecurityGroup == nil {
+		return time.Time{}
+	}
+	return g.SecurityGroup.CreatedAt
+}
+
+func keyPairCreatedAt(g inventory.Group) time.Time {
+	if g.KeyPair == nil {
+		return time.Time{}
+	}
+	return g.KeyPair.CreatedAt
+}
+
+func age(t *time.Time) string {
+	if t == nil {
+		return "unknown"
+	}
+
+	d := time.Since(*t)
+	switch {
+	case d >= time.Hour*24:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
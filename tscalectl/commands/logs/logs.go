@@ -0,0 +1,103 @@
+package logs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+)
+
+var followFlag bool
+
+var LogsCmd = &cobra.Command{
+	Use:   "logs [nodeID string]",
+	Short: "Show provisioning log of tailscale node",
+	Long: "Show full transcript of commands which worked on the node (up, up --resume, down), with debug messages and output " +
+		"of commands run on the node. Logs are kept in ~/.tscalectl/logs after the node is deleted, until its ID is reused.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		tscalectlID, err := strconv.Atoi(args[0])
+		if err != nil {
+			panic(tscerr.Wrap(tscerr.BadInput, err, "provide integer ID for node ID (first 3 numbers of your node name)"))
+		}
+
+		f, err := os.Open(nodeLogFile(state.GetState(ctx), tscalectlID))
+		if err != nil {
+			panic(errors.Wrap(err, "logs, open node log"))
+		}
+		defer f.Close()
+
+		// logs written by older CLI versions may have secrets in them
+		creds.Redact()
+		out := redact.Writer(os.Stdout)
+		defer out.Close()
+
+		for {
+			if _, err = io.Copy(out, f); err != nil {
+				panic(errors.Wrap(err, "logs, print node log"))
+			}
+			if !followFlag {
+				return nil
+			}
+			interrupt.Sleep(ctx, time.Millisecond*500)
+		}
+	},
+}
+
+func init() {
+	LogsCmd.Flags().BoolVarP(&followFlag, "follow", "f", false, "Keep printing new lines until interrupted")
+}
+
+// nodeLogFile returns log file of node in state, or the newest log of a deleted node with the ID
+func nodeLogFile(s *state.State, tscalectlID int) string {
+	if node, ok := s.Nodes[tscalectlID]; ok {
+		if _, err := os.Stat(tscos.NodeLogFile(node.TscalectlName)); err == nil {
+			return tscos.NodeLogFile(node.TscalectlName)
+		}
+	}
+
+	files, err := filepath.Glob(tscos.LogsDir() + "/*.log")
+	if err != nil {
+		panic(errors.Wrap(err, "logs, glob node logs"))
+	}
+
+	newest := ""
+	var newestModTime time.Time
+	for _, f := range files {
+		id, _, _, ok := state.ParseNodeName(strings.TrimSuffix(filepath.Base(f), ".log"))
+		if !ok || id != tscalectlID {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestModTime) {
+			newest = f
+			newestModTime = info.ModTime()
+		}
+	}
+
+	if newest == "" {
+		panic(tscerr.Errorf(tscerr.NotFound, "logs, node has no log; id=%d", tscalectlID))
+	}
+
+	return newest
+}
@@ -0,0 +1,250 @@
+package nuke
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/inventory"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/retry"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/userinput"
+)
+
+var providerFlag string
+var yesFlag bool
+
+var NukeCmd = &cobra.Command{
+	Use:   "nuke",
+	Short: "Delete every tscalectl resource in the account",
+	Long: "Emergency kill switch. Terminate every tscalectl managed instance and delete every security group and key pair " +
+		"in all regions, whether they are in state or not. Afterwards state and local SSH keys are reconciled. " +
+		"JSON and YAML output has `regions` (region, instances, security_groups, key_pairs) with resources found and `deleted`.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		p := provider.New(providerFlag)
+
+		var regions []inventory.Region
+		for _, r := range p.Regions(ctx) {
+			regions = append(regions, inventory.Region{Provider: providerFlag, Region: r})
+		}
+
+		results := inventory.Scan(ctx, regions)
+
+		res := result{Regions: []regionResult{}}
+		total := 0
+		for _, r := range regions {
+			scan := results[r]
+			if scan.Err != nil {
+				tsclog.Warnf("Region %s was not scanned, its resources won't be deleted: %s", r.Region, scan.Err)
+				continue
+			}
+
+			live := liveInstances(scan.Resources)
+			n := len(live) + len(scan.Resources.SecurityGroups) + len(scan.Resources.KeyPairs)
+			if n == 0 {
+				continue
+			}
+			total += n
+			res.Regions = append(res.Regions, regionResult{Region: r.Region, Instances: len(live),
+				SecurityGroups: len(scan.Resources.SecurityGroups), KeyPairs: len(scan.Resources.KeyPairs)})
+			output.Progressf("%-16s %d instance(s), %d security group(s), %d key pair(s)\n", r.Region, len(live), len(scan.Resources.SecurityGroups), len(scan.Resources.KeyPairs))
+		}
+
+		if total == 0 {
+			output.Progressln("There are no tscalectl resources.")
+		} else {
+			output.Progressln()
+			if !yesFlag && userinput.Line("This deletes all listed resources. Type \"nuke\" to continue: ") != "nuke" {
+				output.Progressln("Nothing was deleted.")
+				printResult(res)
+				return nil
+			}
+		}
+
+		// every region is nuked in parallel, failed regions keep their nodes in state
+		failed := make(map[inventory.Region][]error)
+		mu := &sync.Mutex{}
+		wg := &sync.WaitGroup{}
+		for _, r := range regions {
+			res := results[r]
+			if res.Err != nil {
+				failed[r] = []error{res.Err}
+				continue
+			}
+
+			r := r
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if errs := nukeRegion(ctx, p, r.Region, res.Resources); len(errs) > 0 {
+					mu.Lock()
+					failed[r] = errs
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		names := make(map[string]bool)
+		for _, r := range regions {
+			if _, ok := failed[r]; ok {
+				continue
+			}
+			for _, name := range resourceNames(results[r].Resources) {
+				names[name] = true
+			}
+		}
+
+		reconcile(ctx, regions, failed, names)
+
+		if len(failed) > 0 {
+			regionNames := make([]string, 0, len(failed))
+			for r, errs := range failed {
+				regionNames = append(regionNames, r.Region)
+				for _, err := range errs {
+					tsclog.Warnf("%s: %s", r.Region, err)
+				}
+			}
+			sort.Strings(regionNames)
+			panic(errors.Errorf("nuke, some resources were not deleted, run nuke again; regions=%+v", regionNames))
+		}
+
+		output.Progressln("Every tscalectl resource was deleted.")
+		res.Deleted = true
+		printResult(res)
+
+		return nil
+	},
+}
+
+func init() {
+	NukeCmd.Flags().StringVar(&providerFlag, "provider", provider.AWS, "Cloud which is nuked (aws or simulate)")
+	NukeCmd.Flags().BoolVarP(&yesFlag, "yes", "y", false, "Delete without typed confirmation")
+}
+
+// result is the json and yaml output of nuke command
+type result struct {
+	Regions []regionResult `json:"regions"`
+	Deleted bool           `json:"deleted"`
+}
+
+type regionResult struct {
+	Region         string `json:"region"`
+	Instances      int    `json:"instances"`
+	SecurityGroups int    `json:"security_groups"`
+	KeyPairs       int    `json:"key_pairs"`
+}
+
+func printResult(res result) {
+	if output.Structured() {
+		output.Result(res)
+	}
+}
+
+// nukeRegion deletes resources in the same order as the down command, and continues after failures,
+// so as much as possible is deleted
+func nukeRegion(ctx context.Context, p provider.Provider, region string, res provider.Resources) []error {
+	var errs []error
+
+	live := liveInstances(res)
+	for _, inst := range live {
+		inst := inst
+		if err := retryAll(ctx, func(ctx context.Context) { p.TerminateInstance(ctx, region, inst.ID) }); err != nil {
+			errs = append(errs, errors.Wrapf(err, "terminate instance %s", inst.ID))
+		}
+	}
+	for _, inst := range live {
+		inst := inst
+		if err := retryAll(ctx, func(ctx context.Context) { p.WaitForInstanceToTerminate(ctx, region, inst.ID) }); err != nil {
+			errs = append(errs, errors.Wrapf(err, "wait for instance %s to terminate", inst.ID))
+		}
+	}
+	for _, sg := range res.SecurityGroups {
+		sg := sg
+		if err := retryAll(ctx, func(ctx context.Context) { p.DeleteSecurityGroup(ctx, region, sg.ID) }); err != nil {
+			errs = append(errs, errors.Wrapf(err, "delete security group %s", sg.ID))
+		}
+	}
+	for _, kp := range res.KeyPairs {
+		kp := kp
+		if err := retryAll(ctx, func(ctx context.Context) { p.DeleteKeyPair(ctx, region, kp.ID) }); err != nil {
+			errs = append(errs, errors.Wrapf(err, "delete key pair %s", kp.ID))
+		}
+	}
+
+	if len(errs) == 0 && len(live)+len(res.SecurityGroups)+len(res.KeyPairs) > 0 {
+		tsclog.Infof("Nuked %s", region)
+	}
+	return errs
+}
+
+// reconcile removes nodes of nuked regions from state, and deletes local SSH keys of every removed node and
+// nuked resource
+func reconcile(ctx context.Context, regions []inventory.Region, failed map[inventory.Region][]error, names map[string]bool) {
+	nuked := make(map[inventory.Region]bool)
+	for _, r := range regions {
+		if _, ok := failed[r]; !ok {
+			nuked[r] = true
+		}
+	}
+
+	for _, node := range state.GetState(ctx).Nodes {
+		if nuked[inventory.NodeRegion(node)] {
+			state.RemoveNode(ctx, node.TscalectlID)
+			names[node.TscalectlName] = true
+			tsclog.Infof("Removed %s from state", node.TscalectlName)
+		}
+	}
+
+	for name := range names {
+		sshutil.DeleteKeyPair(name)
+	}
+}
+
+// retryAll calls fn with shared retry policy, and retries every failure, because e.g. security group can't be deleted
+// until its instance is terminated
+func retryAll(ctx context.Context, fn func(ctx context.Context)) error {
+	return retry.Do(ctx, retry.Policy{Attempts: retry.Attempts}, func(error) bool { return true }, func(ctx context.Context) (err error) {
+		defer trycatch.ToError(&err)
+		fn(ctx)
+		return nil
+	})
+}
+
+func liveInstances(res provider.Resources) []provider.Instance {
+	var live []provider.Instance
+	for _, inst := range res.Instances {
+		if inst.State != "terminated" {
+			live = append(live, inst)
+		}
+	}
+	return live
+}
+
+func resourceNames(res provider.Resources) []string {
+	var names []string
+	for _, inst := range res.Instances {
+		names = append(names, inst.Name)
+	}
+	for _, sg := range res.SecurityGroups {
+		names = append(names, sg.Name)
+	}
+	for _, kp := range res.KeyPairs {
+		names = append(names, kp.Name)
+	}
+	return names
+}
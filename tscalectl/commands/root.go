@@ -1,24 +1,155 @@
 package commands
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	internalcreds "github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
+	"github.com/svennjegac/tailscale.node-provider/internal/retry"
+	internalstate "github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsccontext"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/context"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/creds"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/down"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/gc"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/logs"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/nuke"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/ssh"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/stateserve"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/up"
 )
 
+var stateURLFlag string
+var contextFlag string
+var verboseFlag bool
+var quietFlag bool
+
 var RootCmd = &cobra.Command{
 	Use:     "tscalectl",
 	Version: "v1.0.0",
+	Long: "tscalectl adds cloud instances into your tailnet.\n\n" +
+		"Exit codes: 0 success, 1 unknown failure, 2 bad input (flags, arguments, prompts), 3 not found (node, instance, AMI, key), " +
+		"4 auth failure (cloud credentials, permissions, SSH host key), 5 capacity (no capacity or account limit reached), 6 timeout, 130 interrupted.",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		output.MustBeValidFormat(output.Format)
+		tsclog.MustBeValidFormat(tsclog.Format)
+		if verboseFlag && quietFlag {
+			panic(tscerr.New(tscerr.BadInput, "root, --verbose and --quiet can't be used together"))
+		}
+		if verboseFlag {
+			tsclog.Verbosity = tsclog.Debug
+		} else if quietFlag {
+			tsclog.Verbosity = tsclog.Warn
+		}
+
+		// flags and arguments are valid, further failures are not usage errors
+		cmd.SilenceUsage = true
+
+		fixPerms()
+
+		cfg := config.Load()
+		config.SetFlags(cmd.Flags(), cfg.Flags())
+		useContext(cmd, cfg)
+		retry.MustBeValid()
+		internalcreds.MustBeValid()
+
+		// lock holder record shows which command holds the lock
+		fileutil.LockCommand = cmd.CommandPath()
+
+		// state serve always serves its local state file
+		if stateURLFlag != "" && cmd != stateserve.ServeCmd {
+			internalstate.SetBackend(internalstate.NewHTTPBackend(stateURLFlag, os.Getenv("TSCALECTL_STATE_TOKEN")))
+		}
+
+		return nil
+	},
 }
 
 func init() {
+	RootCmd.PersistentFlags().DurationVar(&fileutil.LockTimeout, "lock-timeout", fileutil.LockTimeout, "How long to wait for CLI state and files locked by another tscalectl process (0 waits forever)")
+	RootCmd.PersistentFlags().StringVarP(&output.Format, "output", "o", output.Text, "Output format (text, wide, json or yaml), json and yaml print only the command result to stdout and progress to stderr")
+	RootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "Print debug messages, e.g. commands run on the node")
+	RootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "Print only warnings and errors")
+	RootCmd.PersistentFlags().StringVar(&tsclog.Format, "log-format", tsclog.Text, "Format of progress messages (text or json, json prints one JSON object per line)")
+	RootCmd.PersistentFlags().IntVar(&retry.Attempts, "retry-attempts", retry.Attempts, "How many times EC2 calls which failed with throttling, internal AWS error or network failure are attempted (1 disables retries)")
+	RootCmd.PersistentFlags().DurationVar(&retry.BaseDelay, "retry-base-delay", retry.BaseDelay, "Wait before the first retry, it doubles with every retry and is randomized")
+	RootCmd.PersistentFlags().DurationVar(&retry.MaxDelay, "retry-max-delay", retry.MaxDelay, "Longest wait between retries")
+	RootCmd.PersistentFlags().DurationVar(&retry.EC2Timeout, "ec2-timeout", retry.EC2Timeout, "Deadline of a single EC2 call attempt (0 means no deadline)")
+	RootCmd.PersistentFlags().DurationVar(&retry.SSHDialTimeout, "ssh-dial-timeout", retry.SSHDialTimeout, "Deadline of a single SSH connection attempt (0 means no deadline)")
+	RootCmd.PersistentFlags().DurationVar(&retry.SSHReadyTimeout, "ssh-ready-timeout", retry.SSHReadyTimeout, "How long SSH connections are retried while node boots")
+	RootCmd.PersistentFlags().DurationVar(&retry.SSHCommandTimeout, "ssh-command-timeout", retry.SSHCommandTimeout, "Deadline of every command run on node over SSH (0 means no deadline)")
+	RootCmd.PersistentFlags().StringVar(&internalcreds.AWSSource, "aws-creds", internalcreds.AWSSource, "Source of AWS credentials, file (keys in CLI credentials file) or chain (AWS SDK default chain: environment, ~/.aws profiles, SSO, instance role)")
+	RootCmd.PersistentFlags().StringVar(&internalcreds.AWSProfile, "aws-profile", "", "Named profile of ~/.aws/config used by --aws-creds=chain (default is AWS_PROFILE or default profile)")
+	RootCmd.PersistentFlags().StringVar(&internalcreds.AssumeRoleARN, "assume-role-arn", "", "ARN of IAM role assumed with the AWS credentials, nodes are managed with the role")
+	RootCmd.PersistentFlags().StringVar(&internalcreds.TailscaleAuthKeyEnv, "tailscale-auth-key-env", "", "Environment variable with tailscale auth key (default is the key in CLI credentials file)")
+	RootCmd.PersistentFlags().StringVar(&contextFlag, "context", os.Getenv("TSCALECTL_CONTEXT"), "Context (AWS account and tailnet) to use, see context list (default is current context)")
+	RootCmd.PersistentFlags().StringVar(&stateURLFlag, "state-url", os.Getenv("TSCALECTL_STATE_URL"), "URL of shared state served by tscalectl state serve (default is local state file)")
+
+	RootCmd.AddCommand(context.ContextCmd)
 	RootCmd.AddCommand(creds.CredsCmd)
 	RootCmd.AddCommand(down.DownCmd)
+	RootCmd.AddCommand(gc.GCCmd)
+	RootCmd.AddCommand(logs.LogsCmd)
+	RootCmd.AddCommand(nuke.NukeCmd)
 	RootCmd.AddCommand(ssh.SSHCmd)
 	RootCmd.AddCommand(state.StateCmd)
 	RootCmd.AddCommand(up.UpCmd)
+
+	// errors printed by cobra may have secrets in them, cobra prints whole lines, so nothing stays buffered
+	RootCmd.SetErr(redact.Writer(os.Stderr))
+
+	// invalid flags and arguments are bad input, see exit codes
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &tscerr.Error{Kind: tscerr.BadInput, Err: err}
+	})
+	badInputArgs(RootCmd)
+}
+
+// fixPerms makes CLI files private, files written by older CLI versions can be read by other users
+func fixPerms() {
+	fixed := fileutil.FixPerms(tscos.TscalectlDir())
+	for i, f := range fixed {
+		if i == 5 {
+			tsclog.Warnf("... and %d more", len(fixed)-i)
+			break
+		}
+		tsclog.Warnf("Permissions of %s were %#o, other users could access them, changed to %#o", f.Path, f.Perm, f.Perm&^0077)
+	}
+}
+
+// useContext switches to the chosen context. Context commands work even if current context is missing from config,
+// so it can be replaced.
+func useContext(cmd *cobra.Command, cfg config.Config) {
+	name := tsccontext.Name(cfg, contextFlag)
+	if _, ok := cfg.Context(name); !ok && cmd.Parent() == context.ContextCmd && !cmd.Flags().Changed("context") {
+		return
+	}
+
+	tsccontext.Use(name, cfg.MustContext(name), cmd.Flags())
+}
+
+func badInputArgs(cmd *cobra.Command) {
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			if err := args(cmd, a); err != nil {
+				return &tscerr.Error{Kind: tscerr.BadInput, Err: err}
+			}
+			return nil
+		}
+	}
+	for _, c := range cmd.Commands() {
+		badInputArgs(c)
+	}
 }
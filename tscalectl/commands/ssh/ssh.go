@@ -2,36 +2,139 @@ package ssh
 
 import (
 	"fmt"
+	"net"
 	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/svennjegac/tailscale.node-provider/internal/aws/ec2cli"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
 	"github.com/svennjegac/tailscale.node-provider/internal/state"
 	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
 	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
 )
 
+// user is the default user of ubuntu AMIs
+const user = "ubuntu"
+
+var agentLifetimeFlag time.Duration
+
 var SSHCmd = &cobra.Command{
 	Use:   "ssh [nodeID string]",
 	Short: "Print SSH access to tailscale node",
-	Long:  "Command prints you c/p command which can be used for accessing tailscale node. It will find appropriate SSH key and IP address.",
-	Args:  cobra.ExactArgs(1),
+	Long: "Command prints you c/p command which can be used for accessing tailscale node. It will find appropriate SSH key and IP address. " +
+		"json and yaml outputs have fields key_path, user, host, port and command. Host of cloud-init bootstrapped node is its tailnet name. " +
+		"Encrypted private key (see creds encrypt --ssh-keys) is decrypted into ssh-agent for --agent-lifetime, command then selects it by its public key.",
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 		defer trycatch.ToError(&runErr)
 
+		ctx := cmd.Context()
+
 		tscalectlID, err := strconv.Atoi(args[0])
 		if err != nil {
-			panic(errors.Wrap(err, "provide integer ID for node ID (first 3 numbers of your node name)"))
+			panic(tscerr.Wrap(tscerr.BadInput, err, "provide integer ID for node ID (first 3 numbers of your node name)"))
+		}
+
+		node := state.GetNode(ctx, tscalectlID)
+		if node.NoSSHAccess {
+			panic(tscerr.Errorf(tscerr.NotFound, "ssh, node was imported without its private key, CLI has no SSH access to it; node=%s", node.TscalectlName))
+		}
+
+		keyPath := tscos.AwsKeyPairsDir() + "/" + node.TscalectlName + ".pem"
+		identity := "-i " + keyPath
+		if sshutil.IsPrivateKeySealed(node.TscalectlName) {
+			keyPath = sshutil.AddToAgent(node.TscalectlName, agentLifetimeFlag)
+			identity = "-o IdentitiesOnly=yes -i " + keyPath
+			tsclog.Infof("Private key is encrypted, it was added to ssh-agent for %s", agentLifetimeFlag)
 		}
 
-		node := state.GetNode(tscalectlID)
+		// security group of cloud-init bootstrapped node does not allow SSH from the internet, node is reachable through tailnet
+		if node.Bootstrap == state.BootstrapCloudInit {
+			printResult(result{
+				KeyPath: keyPath,
+				User:    user,
+				Host:    node.TscalectlName,
+				Port:    22,
+				Command: fmt.Sprintf("ssh -tt %s %s@$(tailscale ip -4 %s)", identity, user, node.TscalectlName),
+			})
+			return nil
+		}
+
+		p := provider.New(node.Provider)
 
-		ec2InstancePublicIP := ec2cli.DescribeInstance(node.Region, node.TscalectlName)
+		var inst provider.Instance
+		if node.InstanceID != "" {
+			inst = p.DescribeInstance(ctx, node.Region, node.InstanceID)
+		} else {
+			// node created by older tscalectl version, instance ID is stored once it is found by name
+			var ok bool
+			inst, ok = p.FindInstance(ctx, node.Region, node.TscalectlName)
+			if !ok {
+				panic(tscerr.Errorf(tscerr.NotFound, "ssh, instance of the node does not exist; node=%s", node.TscalectlName))
+			}
+			node.InstanceID = inst.ID
+		}
 
-		fmt.Printf("ssh -tt -i %s ubuntu@%s\n", tscos.AwsKeyPairsDir()+"/"+node.TscalectlName+".pem", ec2InstancePublicIP)
+		// public IP changes when instance is stopped and started again
+		node.PublicIP = inst.PublicIP
+		node.PrivateIP = inst.PrivateIP
+		state.UpdateNode(ctx, node)
+
+		host := p.SSHAddress(inst)
+		if host == "" {
+			panic(tscerr.Errorf(tscerr.NotFound, "ssh, instance has no public IP; instance-state=%s", inst.State))
+		}
+
+		ip, port, err := net.SplitHostPort(sshutil.Addr(host))
+		if err != nil {
+			panic(errors.Wrap(err, "ssh, split host port"))
+		}
+
+		portNum, err := strconv.Atoi(port)
+		if err != nil {
+			panic(errors.Wrap(err, "ssh, parse port"))
+		}
+
+		portFlag := ""
+		if port != "22" {
+			portFlag = " -p " + port
+		}
+
+		printResult(result{
+			KeyPath: keyPath,
+			User:    user,
+			Host:    ip,
+			Port:    portNum,
+			Command: fmt.Sprintf("ssh -tt %s%s %s@%s", identity, portFlag, user, ip),
+		})
 
 		return nil
 	},
 }
+
+func init() {
+	SSHCmd.Flags().DurationVar(&agentLifetimeFlag, "agent-lifetime", time.Minute*10, "How long ssh-agent keeps decrypted private key of encrypted node key")
+}
+
+// result is the json and yaml output of ssh command, key_path of encrypted key is its public key in ssh-agent
+type result struct {
+	KeyPath string `json:"key_path"`
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	Command string `json:"command"`
+}
+
+func printResult(r result) {
+	if output.Structured() {
+		output.Result(r)
+		return
+	}
+	fmt.Println(r.Command)
+}
@@ -4,7 +4,13 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/statedump"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/stateimport"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/statelist"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/statemigrate"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/staterefresh"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/staterestore"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/stateserve"
+	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands/state/stateunlock"
 )
 
 var StateCmd = &cobra.Command{
@@ -16,5 +22,11 @@ var StateCmd = &cobra.Command{
 
 func init() {
 	StateCmd.AddCommand(statedump.DumpCmd)
+	StateCmd.AddCommand(stateimport.ImportCmd)
 	StateCmd.AddCommand(statelist.ListCmd)
+	StateCmd.AddCommand(statemigrate.MigrateCmd)
+	StateCmd.AddCommand(staterefresh.RefreshCmd)
+	StateCmd.AddCommand(staterestore.RestoreCmd)
+	StateCmd.AddCommand(stateserve.ServeCmd)
+	StateCmd.AddCommand(stateunlock.UnlockCmd)
 }
@@ -3,10 +3,14 @@ package statedump
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/redact"
 	"github.com/svennjegac/tailscale.node-provider/internal/state"
 	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
 )
@@ -14,19 +18,31 @@ import (
 var DumpCmd = &cobra.Command{
 	Use:   "dump",
 	Short: "Dump state JSON",
-	Long:  "Dump state JSON and its internal details",
+	Long:  "Dump state JSON and its internal details. json and yaml outputs are the state document (fields schema_version, nodes by ID and last_id) as it is stored.",
 	Args:  cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 		defer trycatch.ToError(&runErr)
 
-		s := state.GetState()
+		ctx := cmd.Context()
+
+		s := state.GetState(ctx)
+
+		// dump is often attached to bug reports, secrets must not get into it
+		creds.Redact()
+		out := redact.Writer(os.Stdout)
+		defer out.Close()
+
+		if output.Structured() {
+			output.Write(out, output.Format, s)
+			return nil
+		}
 
 		b, err := json.MarshalIndent(s, "", "  ")
 		if err != nil {
 			panic(errors.Wrap(err, "state dump, json marshal"))
 		}
 
-		fmt.Println(string(b))
+		fmt.Fprintln(out, string(b))
 
 		return nil
 	},
@@ -0,0 +1,166 @@
+package stateimport
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/inventory"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+var providerFlag string
+var regionFlag string
+var dryRunFlag bool
+
+var ImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Adopt tscalectl managed resources into state",
+	Long: "Scan all regions for instances, security groups and key pairs created by tscalectl and add nodes which are not in state of any context. " +
+		"Nodes whose private key is missing locally are marked as \"no SSH access\". (Exit node setting can't be recovered, imported nodes are shown as non exit nodes) " +
+		"JSON and YAML output has `nodes` (name, instance_id, status, bootstrap, ssh_access), `dry_run` and `skipped` count of resource sets without instance.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		p := provider.New(providerFlag)
+
+		regionNames := p.Regions(ctx)
+		if regionFlag != "" {
+			regionNames = []string{regionFlag}
+		}
+		regions := make([]inventory.Region, 0, len(regionNames))
+		for _, r := range regionNames {
+			regions = append(regions, inventory.Region{Provider: providerFlag, Region: r})
+		}
+
+		s := state.GetState(ctx)
+		states := inventory.States(s)
+		results := inventory.Scan(ctx, regions)
+
+		var nodes []*state.VPNNode
+		skipped := 0
+		for _, r := range regions {
+			res := results[r]
+			if res.Err != nil {
+				tsclog.Warnf("Region %s was not scanned: %s", r.Region, res.Err)
+				continue
+			}
+
+			for _, g := range inventory.Untracked(states, r, res.Resources) {
+				if g.Instance == nil {
+					skipped++
+					continue
+				}
+				if _, _, _, ok := state.ParseNodeName(g.Name); !ok {
+					tsclog.Warnf("Instance %s in %s is not named as tscalectl node, skipping it; name=%s", g.Instance.ID, r.Region, g.Name)
+					continue
+				}
+				nodes = append(nodes, nodeFromGroup(g))
+			}
+		}
+
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].TscalectlName < nodes[j].TscalectlName
+		})
+
+		res := result{Nodes: make([]importedNode, 0, len(nodes)), DryRun: dryRunFlag, Skipped: skipped}
+		for _, n := range nodes {
+			res.Nodes = append(res.Nodes, importedNode{Name: n.TscalectlName, InstanceID: n.InstanceID, Status: n.Status, Bootstrap: n.Bootstrap, SSHAccess: !n.NoSSHAccess})
+		}
+
+		if len(nodes) == 0 {
+			output.Progressln("There are no untracked nodes to import.")
+		} else {
+			if !output.Structured() {
+				rows := make([][]string, 0, len(res.Nodes))
+				for _, n := range res.Nodes {
+					rows = append(rows, []string{n.Name, n.InstanceID, n.Status, n.Bootstrap, strconv.FormatBool(n.SSHAccess)})
+				}
+				output.PrintTable([]string{"NAME", "INSTANCE", "STATUS", "BOOTSTRAP", "SSH ACCESS"}, rows)
+			}
+
+			if dryRunFlag {
+				output.Progressf("\nDry run, %d node(s) would be imported.\n", len(nodes))
+			} else {
+				state.ImportNodes(ctx, nodes)
+				output.Progressf("\nImported %d node(s).\n", len(nodes))
+			}
+		}
+
+		if skipped > 0 {
+			output.Progressf("%d set(s) of security groups and key pairs without instance were not imported.\n", skipped)
+		}
+
+		if output.Structured() {
+			output.Result(res)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	ImportCmd.Flags().StringVar(&providerFlag, "provider", provider.AWS, "Cloud which is scanned (aws or simulate)")
+	ImportCmd.Flags().StringVarP(&regionFlag, "region", "r", "", "Scan only this region (default is all regions)")
+	ImportCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Show nodes which would be imported without changing the state")
+}
+
+// result is the json and yaml output of state import command
+type result struct {
+	Nodes   []importedNode `json:"nodes"`
+	DryRun  bool           `json:"dry_run"`
+	Skipped int            `json:"skipped"`
+}
+
+type importedNode struct {
+	Name       string `json:"name"`
+	InstanceID string `json:"instance_id"`
+	Status     string `json:"status"`
+	Bootstrap  string `json:"bootstrap"`
+	SSHAccess  bool   `json:"ssh_access"`
+}
+
+func nodeFromGroup(g inventory.Group) *state.VPNNode {
+	inst := g.Instance
+
+	node := &state.VPNNode{
+		TscalectlName:    g.Name,
+		CreatedAt:        inst.LaunchedAt,
+		Provider:         g.Region.Provider,
+		Region:           g.Region.Region,
+		InstanceType:     inst.InstanceType,
+		AMI:              inst.AMI,
+		InstanceID:       inst.ID,
+		AvailabilityZone: inst.AvailabilityZone,
+		SubnetID:         inst.SubnetID,
+		PublicIP:         inst.PublicIP,
+		PrivateIP:        inst.PrivateIP,
+		NoSSHAccess:      !sshutil.HasPrivateKey(g.Name),
+	}
+
+	// cloud-init bootstrapped nodes don't open SSH port
+	node.Bootstrap = state.BootstrapSSH
+	if g.SecurityGroup != nil {
+		node.SecurityGroupID = g.SecurityGroup.ID
+		if !g.SecurityGroup.SSHIngress {
+			node.Bootstrap = state.BootstrapCloudInit
+		}
+	}
+	if g.KeyPair != nil {
+		node.KeyPairID = g.KeyPair.ID
+	}
+
+	node.Steps = state.AllSteps(node.Bootstrap)
+	node.Status = inventory.CheckNode(node, provider.Resources{Instances: []provider.Instance{*inst}}).Status
+
+	return node
+}
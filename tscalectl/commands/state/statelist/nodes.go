@@ -0,0 +1,138 @@
+package statelist
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/cost"
+	"github.com/svennjegac/tailscale.node-provider/internal/inventory"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/tailnet"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+// Node is the schema of json and yaml output, fields are only added, never renamed or removed
+type Node struct {
+	ID               int       `json:"id" yaml:"id"`
+	Name             string    `json:"name" yaml:"name"`
+	Provider         string    `json:"provider" yaml:"provider"`
+	Region           string    `json:"region" yaml:"region"`
+	AvailabilityZone string    `json:"availability_zone" yaml:"availability_zone"`
+	InstanceID       string    `json:"instance_id" yaml:"instance_id"`
+	InstanceType     string    `json:"instance_type" yaml:"instance_type"`
+	State            string    `json:"state" yaml:"state"`
+	PublicIP         string    `json:"public_ip" yaml:"public_ip"`
+	PrivateIP        string    `json:"private_ip" yaml:"private_ip"`
+	TailscaleIP      string    `json:"tailscale_ip" yaml:"tailscale_ip"`
+	ExitNode         bool      `json:"exit_node" yaml:"exit_node"`
+	Bootstrap        string    `json:"bootstrap" yaml:"bootstrap"`
+	CreatedAt        time.Time `json:"created_at" yaml:"created_at"`
+	AgeSeconds       int64     `json:"age_seconds" yaml:"age_seconds"`
+	// EstimatedCostUSD is nil if price of the instance type is unknown
+	EstimatedCostUSD *float64 `json:"estimated_cost_usd" yaml:"estimated_cost_usd"`
+	// MarkedForCleanup nodes are dead, `down` or `gc` deletes them with their leftovers
+	MarkedForCleanup bool `json:"marked_for_cleanup" yaml:"marked_for_cleanup"`
+	// Context is set only by --all-contexts
+	Context string `json:"context,omitempty" yaml:"context,omitempty"`
+}
+
+var sortKeys = []string{"id", "name", "region", "state", "age", "cost"}
+
+// liveNodes combines state with live cloud data, regions are queried concurrently
+func liveNodes(ctx context.Context, s *state.State) []Node {
+	results := inventory.Scan(ctx, inventory.StateRegions(s))
+	tailscaleIPs := tailnet.IPv4s()
+
+	nodes := make([]Node, 0, len(s.Nodes))
+	for _, vpnNode := range s.Nodes {
+		n := Node{
+			ID:               vpnNode.TscalectlID,
+			Name:             vpnNode.TscalectlName,
+			Provider:         inventory.NodeRegion(vpnNode).Provider,
+			Region:           vpnNode.Region,
+			AvailabilityZone: vpnNode.AvailabilityZone,
+			InstanceID:       vpnNode.InstanceID,
+			InstanceType:     vpnNode.InstanceType,
+			PublicIP:         vpnNode.PublicIP,
+			PrivateIP:        vpnNode.PrivateIP,
+			TailscaleIP:      tailscaleIPs[vpnNode.TscalectlName],
+			ExitNode:         vpnNode.ExitNode,
+			Bootstrap:        vpnNode.Bootstrap,
+			MarkedForCleanup: vpnNode.MarkedForCleanup,
+			CreatedAt:        vpnNode.CreatedAt,
+			AgeSeconds:       int64(time.Since(vpnNode.CreatedAt).Seconds()),
+		}
+
+		res := results[inventory.NodeRegion(vpnNode)]
+		if res.Err != nil {
+			n.State = "unknown"
+			tsclog.Warnf("Region %s was not queried: %s", vpnNode.Region, res.Err)
+		} else {
+			c := inventory.CheckNode(vpnNode, res.Resources)
+			n.State = c.Status
+			if c.Instance != nil {
+				n.InstanceID = c.Instance.ID
+				n.PublicIP = c.Instance.PublicIP
+				n.PrivateIP = c.Instance.PrivateIP
+			}
+		}
+
+		// simulated instances are free
+		if n.Provider == provider.Simulate {
+			n.EstimatedCostUSD = new(float64)
+		} else if usd, ok := cost.Estimate(n.InstanceType, n.CreatedAt); ok {
+			n.EstimatedCostUSD = &usd
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func sortNodes(nodes []Node, key string) {
+	less := map[string]func(a, b Node) bool{
+		"id":     func(a, b Node) bool { return a.ID < b.ID },
+		"name":   func(a, b Node) bool { return a.Name < b.Name },
+		"region": func(a, b Node) bool { return a.Region < b.Region },
+		"state":  func(a, b Node) bool { return a.State < b.State },
+		"age":    func(a, b Node) bool { return a.CreatedAt.Before(b.CreatedAt) },
+		"cost":   func(a, b Node) bool { return costOf(a) < costOf(b) },
+	}[key]
+	if less == nil {
+		panic(tscerr.Errorf(tscerr.BadInput, "state list, invalid sort key; sort=%s, allowed-sort-keys=%+v", key, sortKeys))
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if less(nodes[i], nodes[j]) {
+			return true
+		}
+		if less(nodes[j], nodes[i]) {
+			return false
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+}
+
+func costOf(n Node) float64 {
+	if n.EstimatedCostUSD == nil {
+		return -1
+	}
+	return *n.EstimatedCostUSD
+}
+
+func age(createdAt time.Time) string {
+	d := time.Since(createdAt)
+	switch {
+	case d >= time.Hour*24:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
@@ -1,43 +1,149 @@
 package statelist
 
 import (
+	"context"
 	"fmt"
-	"sort"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"github.com/svennjegac/tailscale.node-provider/internal/config"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
 	"github.com/svennjegac/tailscale.node-provider/internal/state"
 	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsccontext"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscos"
 )
 
+var sortFlag string
+var watchFlag bool
+var intervalFlag time.Duration
+var allContextsFlag bool
+
+// flags which choose context or its settings, --all-contexts uses settings of every context instead
+var contextFlags = []string{"context", "aws-creds", "aws-profile", "assume-role-arn", "tailscale-auth-key-env"}
+
 var ListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "Show state",
-	Long:  "Show currently deployed tailscale nodes in AWS.",
-	Args:  cobra.ExactArgs(0),
+	Long: "Show deployed tailscale nodes with their live instance state, IPs, tailscale IP (from local tailscale CLI) and estimated cost so far. " +
+		"json and yaml outputs are lists of nodes with fields id, name, provider, region, availability_zone, instance_id, instance_type, state, " +
+		"public_ip, private_ip, tailscale_ip, exit_node, bootstrap, created_at, age_seconds and estimated_cost_usd, and context with --all-contexts.",
+	Args: cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 		defer trycatch.ToError(&runErr)
 
-		s := state.GetState()
-		fmt.Printf("You have %d AWS nodes deployed.\n\n", len(s.Nodes))
+		ctx := cmd.Context()
 
-		nodes := make([]*state.VPNNode, 0, len(s.Nodes))
-		for _, node := range s.Nodes {
-			nodes = append(nodes, node)
+		if allContextsFlag {
+			state.MustUseFileBackend("state list --all-contexts")
+			for _, f := range contextFlags {
+				if cmd.Flags().Changed(f) {
+					panic(tscerr.Errorf(tscerr.BadInput, "state list, --all-contexts uses settings of every context, it can't be used with --%s", f))
+				}
+			}
 		}
 
-		sort.Slice(nodes, func(i, j int) bool {
-			if nodes[i].TscalectlID < nodes[j].TscalectlID {
-				return true
+		for {
+			var nodes []Node
+			if allContextsFlag {
+				nodes = allContextsNodes(ctx, cmd.Flags())
+			} else {
+				nodes = liveNodes(ctx, state.GetState(ctx))
+				sortNodes(nodes, sortFlag)
 			}
-			return false
-		})
 
-		for i, node := range nodes {
-			fmt.Printf("%d - %s, age: %s\n", i, node.TscalectlName, time.Since(node.CreatedAt))
-		}
+			if watchFlag && !output.Structured() {
+				// clear terminal
+				fmt.Print("\033[H\033[2J")
+			}
+			printNodes(nodes)
 
-		return nil
+			if !watchFlag {
+				return nil
+			}
+			interrupt.Sleep(ctx, intervalFlag)
+		}
 	},
 }
+
+func init() {
+	ListCmd.Flags().StringVar(&sortFlag, "sort", "id", "Sort nodes by id, name, region, state, age or cost")
+	ListCmd.Flags().BoolVarP(&watchFlag, "watch", "w", false, "Refresh the list until interrupted")
+	ListCmd.Flags().DurationVar(&intervalFlag, "interval", time.Second*10, "Refresh interval of --watch")
+	ListCmd.Flags().BoolVar(&allContextsFlag, "all-contexts", false, "Show nodes of every context, grouped by context")
+}
+
+// allContextsNodes switches to every context in turn and returns nodes of all of them, current context is used again
+// afterwards
+func allContextsNodes(ctx context.Context, flags *pflag.FlagSet) []Node {
+	cfg := config.Load()
+	current := tscos.Context
+	defer tsccontext.Use(current, cfg.MustContext(current), flags)
+
+	var nodes []Node
+	for _, name := range cfg.ContextNames() {
+		tsccontext.Use(name, cfg.MustContext(name), flags)
+
+		contextNodes := liveNodes(ctx, state.GetState(ctx))
+		sortNodes(contextNodes, sortFlag)
+		for i := range contextNodes {
+			contextNodes[i].Context = name
+		}
+		nodes = append(nodes, contextNodes...)
+	}
+
+	return nodes
+}
+
+func printNodes(nodes []Node) {
+	if output.Structured() {
+		output.Result(nodes)
+		return
+	}
+
+	fmt.Printf("You have %d nodes deployed.\n\n", len(nodes))
+	if len(nodes) == 0 {
+		return
+	}
+
+	headers := []string{"ID", "NAME", "STATE", "PUBLIC IP", "TAILSCALE IP", "EXIT NODE", "TYPE", "AGE", "COST"}
+	if output.Format == output.Wide {
+		headers = append(headers, "PROVIDER", "REGION", "ZONE", "INSTANCE ID", "PRIVATE IP", "BOOTSTRAP")
+	}
+	if allContextsFlag {
+		headers = append([]string{"CONTEXT"}, headers...)
+	}
+
+	rows := make([][]string, 0, len(nodes))
+	for _, n := range nodes {
+		exitNode := ""
+		if n.ExitNode {
+			exitNode = "yes"
+		}
+		nodeCost := ""
+		if n.EstimatedCostUSD != nil {
+			nodeCost = fmt.Sprintf("$%.2f", *n.EstimatedCostUSD)
+		}
+
+		nodeState := n.State
+		if n.MarkedForCleanup {
+			nodeState += " (marked for cleanup)"
+		}
+
+		row := []string{strconv.Itoa(n.ID), n.Name, nodeState, n.PublicIP, n.TailscaleIP, exitNode, n.InstanceType, age(n.CreatedAt), nodeCost}
+		if output.Format == output.Wide {
+			row = append(row, n.Provider, n.Region, n.AvailabilityZone, n.InstanceID, n.PrivateIP, n.Bootstrap)
+		}
+		if allContextsFlag {
+			row = append([]string{n.Context}, row...)
+		}
+		rows = append(rows, row)
+	}
+
+	output.PrintTable(headers, rows)
+}
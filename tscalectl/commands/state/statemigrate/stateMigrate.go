@@ -0,0 +1,84 @@
+package statemigrate
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+)
+
+var dryRunFlag bool
+
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate state to the current schema version",
+	Long: "Migrate state file to the schema version of this CLI. (State is migrated automatically when it is loaded, this command shows what changes) " +
+		"JSON and YAML output has `from_version`, `to_version`, `migrations`, `changes` and `dry_run`.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		report := state.Migrate(ctx, dryRunFlag)
+
+		if output.Structured() {
+			output.Result(result{
+				FromVersion: report.FromVersion,
+				ToVersion:   report.ToVersion,
+				Migrations:  nonNil(report.Migrations),
+				Changes:     nonNil(report.Changes),
+				DryRun:      dryRunFlag,
+			})
+			return nil
+		}
+
+		if len(report.Migrations) == 0 {
+			output.Progressf("State is already at schema version %d, nothing to migrate.\n", report.ToVersion)
+			return nil
+		}
+
+		output.Progressf("Schema version: %d -> %d\n\n", report.FromVersion, report.ToVersion)
+
+		output.Progressln("Migrations:")
+		for _, m := range report.Migrations {
+			output.Progressf("  %s\n", m)
+		}
+
+		output.Progressln()
+		output.Progressln("Changes:")
+		for _, c := range report.Changes {
+			output.Progressf("  %s\n", c)
+		}
+
+		output.Progressln()
+		if dryRunFlag {
+			output.Progressln("Dry run, state was not changed.")
+		} else {
+			output.Progressln("State migrated.")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	MigrateCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Show what would change without changing the state")
+}
+
+// result is the json and yaml output of state migrate command
+type result struct {
+	FromVersion int      `json:"from_version"`
+	ToVersion   int      `json:"to_version"`
+	Migrations  []string `json:"migrations"`
+	Changes     []string `json:"changes"`
+	DryRun      bool     `json:"dry_run"`
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,169 @@
+package staterefresh
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/inventory"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/userinput"
+)
+
+var pruneFlag bool
+var markFlag bool
+
+var RefreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Compare state with live cloud resources",
+	Long: "Query every region in state, store status of each node (running, stopped, terminated, missing or provisioning) and print drift report. " +
+		"Dead nodes (terminated or missing) can be pruned from state or marked for cleanup with `down` or `gc`. " +
+		"Nodes whose instance was not launched yet (`up` is running or failed with --keep-on-failure) are not dead. " +
+		"JSON and YAML output has `nodes` (id, name, status, drift, dead), `pruned` and `marked` node names.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		res := result{Nodes: []nodeResult{}, Pruned: []string{}, Marked: []string{}}
+
+		s := state.GetState(ctx)
+		if len(s.Nodes) == 0 {
+			output.Progressln("There are no nodes in state.")
+			printResult(res)
+			return nil
+		}
+
+		results := inventory.Scan(ctx, inventory.StateRegions(s))
+
+		nodes := make([]*state.VPNNode, 0, len(s.Nodes))
+		for _, node := range s.Nodes {
+			nodes = append(nodes, node)
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].TscalectlID < nodes[j].TscalectlID
+		})
+
+		var dead []inventory.NodeCheck
+		for _, node := range nodes {
+			scan := results[inventory.NodeRegion(node)]
+			if scan.Err != nil {
+				res.Nodes = append(res.Nodes, nodeResult{ID: node.TscalectlID, Name: node.TscalectlName, Status: "unknown",
+					Drift: []string{"region scan failed: " + scan.Err.Error()}})
+				continue
+			}
+
+			c := inventory.CheckNode(node, scan.Resources)
+
+			drift := append(append([]string{}, c.Drift...), prefix("leftover ", c.Leftovers)...)
+			res.Nodes = append(res.Nodes, nodeResult{ID: node.TscalectlID, Name: node.TscalectlName, Status: c.Status, Drift: drift, Dead: c.Dead()})
+
+			node.Status = c.Status
+			// instance of marked node was found again (e.g. region was not reachable before)
+			if !c.Dead() {
+				node.MarkedForCleanup = false
+			}
+			if c.Instance != nil && !c.Dead() {
+				node.PublicIP = c.Instance.PublicIP
+				node.PrivateIP = c.Instance.PrivateIP
+			}
+			state.UpdateNode(ctx, node)
+
+			if c.Dead() {
+				dead = append(dead, c)
+			}
+		}
+
+		if !output.Structured() {
+			printReport(res.Nodes)
+		}
+
+		if len(dead) == 0 {
+			printResult(res)
+			return nil
+		}
+
+		output.Progressln()
+		output.Progressf("%d dead node(s) in state.\n", len(dead))
+
+		prune, mark := pruneFlag, markFlag
+		if !prune && !mark {
+			switch strings.ToLower(userinput.Line("Prune them from state (p), mark them for cleanup (m) or leave state unchanged (n)? [p/m/N]: ")) {
+			case "p":
+				prune = true
+			case "m":
+				mark = true
+			}
+		}
+
+		for _, c := range dead {
+			switch {
+			case prune:
+				state.RemoveNode(ctx, c.Node.TscalectlID)
+				sshutil.DeleteKeyPair(c.Node.TscalectlName)
+				res.Pruned = append(res.Pruned, c.Node.TscalectlName)
+				output.Progressf("Pruned %s\n", c.Node.TscalectlName)
+				for _, l := range c.Leftovers {
+					output.Progressf("  %s is left in the cloud\n", l)
+				}
+			case mark && !c.Node.MarkedForCleanup:
+				c.Node.MarkedForCleanup = true
+				state.UpdateNode(ctx, c.Node)
+				res.Marked = append(res.Marked, c.Node.TscalectlName)
+				output.Progressf("Marked %s for cleanup, delete it with its leftovers with `tscalectl down %d` or `tscalectl gc`\n", c.Node.TscalectlName, c.Node.TscalectlID)
+			}
+		}
+
+		printResult(res)
+		return nil
+	},
+}
+
+func init() {
+	RefreshCmd.Flags().BoolVar(&pruneFlag, "prune", false, "Remove dead nodes from state without asking")
+	RefreshCmd.Flags().BoolVar(&markFlag, "mark", false, "Mark dead nodes for cleanup without asking")
+	RefreshCmd.MarkFlagsMutuallyExclusive("prune", "mark")
+}
+
+// result is the json and yaml output of state refresh command
+type result struct {
+	Nodes  []nodeResult `json:"nodes"`
+	Pruned []string     `json:"pruned"`
+	Marked []string     `json:"marked"`
+}
+
+type nodeResult struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Drift  []string `json:"drift"`
+	Dead   bool     `json:"dead"`
+}
+
+func printResult(res result) {
+	if output.Structured() {
+		output.Result(res)
+	}
+}
+
+func printReport(nodes []nodeResult) {
+	rows := make([][]string, 0, len(nodes))
+	for _, n := range nodes {
+		rows = append(rows, []string{strconv.Itoa(n.ID), n.Name, n.Status, strings.Join(n.Drift, "; ")})
+	}
+	output.PrintTable([]string{"ID", "NAME", "STATUS", "DRIFT"}, rows)
+}
+
+func prefix(p string, values []string) []string {
+	prefixed := make([]string, 0, len(values))
+	for _, v := range values {
+		prefixed = append(prefixed, p+v)
+	}
+	return prefixed
+}
@@ -0,0 +1,88 @@
+package staterestore
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+var RestoreCmd = &cobra.Command{
+	Use:   "restore [generation]",
+	Short: "Restore state from a backup generation",
+	Long: "Restore state file from a backup generation. Generation 1 is the state before the last change, 2 the one before it, ... " +
+		"Without generation, available generations are listed. (Replaced state becomes generation 1, so restore can be undone) " +
+		"JSON and YAML output is list of `generations` (generation, saved_at, nodes, error), or `restored` generation.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		if len(args) == 0 {
+			gens := state.ListGenerations(ctx)
+
+			if output.Structured() {
+				res := listResult{Generations: make([]generation, 0, len(gens))}
+				for _, g := range gens {
+					gen := generation{Generation: g.Generation, SavedAt: g.SavedAt, Nodes: g.Nodes}
+					if g.Err != nil {
+						gen.Error = g.Err.Error()
+					}
+					res.Generations = append(res.Generations, gen)
+				}
+				output.Result(res)
+				return nil
+			}
+
+			if len(gens) == 0 {
+				output.Progressln("There are no state backup generations.")
+				return nil
+			}
+
+			for _, g := range gens {
+				if g.Err != nil {
+					output.Progressf("%3d  %s  corrupt: %s\n", g.Generation, g.SavedAt.Format(time.RFC3339), g.Err)
+					continue
+				}
+				output.Progressf("%3d  %s  %d node(s)\n", g.Generation, g.SavedAt.Format(time.RFC3339), g.Nodes)
+			}
+			return nil
+		}
+
+		generation, err := strconv.Atoi(args[0])
+		if err != nil {
+			panic(tscerr.Wrap(tscerr.BadInput, err, "state restore, generation must be a number"))
+		}
+
+		state.Restore(ctx, generation)
+
+		output.Progressf("State restored from generation %d.\n", generation)
+		if output.Structured() {
+			output.Result(restoreResult{Restored: generation})
+		}
+
+		return nil
+	},
+}
+
+// listResult and restoreResult are the json and yaml output of state restore command
+type listResult struct {
+	Generations []generation `json:"generations"`
+}
+
+type generation struct {
+	Generation int       `json:"generation"`
+	SavedAt    time.Time `json:"saved_at"`
+	Nodes      int       `json:"nodes"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type restoreResult struct {
+	Restored int `json:"restored"`
+}
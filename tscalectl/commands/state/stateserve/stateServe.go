@@ -0,0 +1,57 @@
+package stateserve
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+var listenFlag string
+
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve local state to other CLIs",
+	Long: "Serve local state file over HTTP, so a team can share one node inventory. " +
+		"Other CLIs use it with --state-url (or TSCALECTL_STATE_URL). " +
+		"Set TSCALECTL_STATE_TOKEN on the server and the clients to require a bearer token. (Serve it only on a trusted network, e.g. tailnet)",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		token := os.Getenv("TSCALECTL_STATE_TOKEN")
+		if token == "" {
+			tsclog.Warnf("TSCALECTL_STATE_TOKEN is not set, state is served without authorization")
+		}
+
+		tsclog.Infof("Serving CLI state on http://%s", listenFlag)
+
+		srv := &http.Server{Addr: listenFlag, Handler: state.NewServer(token)}
+
+		// interrupt stops the server after requests in flight are answered
+		go func() {
+			<-cmd.Context().Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			_ = srv.Shutdown(ctx)
+		}()
+
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			panic(errors.Wrap(err, "state serve, listen and serve"))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	ServeCmd.Flags().StringVar(&listenFlag, "listen", "127.0.0.1:7450", "Address on which state is served")
+}
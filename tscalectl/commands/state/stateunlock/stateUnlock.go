@@ -0,0 +1,62 @@
+package stateunlock
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+)
+
+var forceFlag bool
+
+var UnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Show and clear state lock",
+	Long: "Show which process holds the state lock. With --force the lock is cleared. " +
+		"(Locking process is not stopped, make sure it is hung before forcing, otherwise both processes can change the state) " +
+		"JSON and YAML output has `locked`, `holder` (pid, command, started_at) and `cleared`.",
+	Args: cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
+		defer trycatch.ToError(&runErr)
+
+		ctx := cmd.Context()
+
+		holder, locked := state.LockHolder(ctx)
+		if !locked {
+			output.Progressln("State is not locked.")
+			printResult(result{})
+			return nil
+		}
+
+		output.Progressf("State is locked by %s\n", holder)
+		if !forceFlag {
+			panic(tscerr.New(tscerr.BadInput, "state unlock, lock is held, use --force to clear it"))
+		}
+
+		state.ForceUnlock(ctx)
+		output.Progressln("State lock cleared.")
+		printResult(result{Locked: true, Holder: &holder, Cleared: true})
+
+		return nil
+	},
+}
+
+func init() {
+	UnlockCmd.Flags().BoolVar(&forceFlag, "force", false, "Clear the lock even though it is held by another process")
+}
+
+// result is the json and yaml output of state unlock command, held lock without --force is an error
+type result struct {
+	Locked  bool                 `json:"locked"`
+	Holder  *fileutil.LockHolder `json:"holder,omitempty"`
+	Cleared bool                 `json:"cleared"`
+}
+
+func printResult(res result) {
+	if output.Structured() {
+		output.Result(res)
+	}
+}
@@ -0,0 +1,218 @@
+package up
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/cloudinit"
+	"github.com/svennjegac/tailscale.node-provider/internal/creds"
+	"github.com/svennjegac/tailscale.node-provider/internal/fileutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
+	"github.com/svennjegac/tailscale.node-provider/internal/rollback"
+	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/state"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
+)
+
+type step struct {
+	id       state.Step
+	progress string
+	// resource created by the step, nil undo means step does not create anything which should be rolled back
+	resource string
+	do       func()
+	undo     func()
+}
+
+// provision runs provisioning steps which are not finished yet. Every finished step is persisted in CLI state,
+// so interrupted provisioning can be continued with `up --resume`. Failure rolls back only what this run created,
+// resumed node keeps resources of earlier runs and its state entry.
+func provision(ctx context.Context, vpnNode *state.VPNNode, resume bool) {
+	p := provider.New(vpnNode.Provider)
+	name := vpnNode.TscalectlName
+	region := vpnNode.Region
+	cloudInit := vpnNode.Bootstrap == state.BootstrapCloudInit
+
+	// rollback runs after ctx is canceled by the first interrupt, so it must not use ctx
+	// (the second interrupt exits right away and prints what was left behind)
+	cleanupCtx := context.Background()
+
+	// every created resource is recorded, and on failure or interrupt undone in reverse order (same order as in down command)
+	rb := rollback.New()
+	defer interrupt.OnForceExit(rb.PrintLeftovers)()
+	defer rb.OnPanic(keepOnFailureFlag)
+	rb.Hint = fmt.Sprintf("Run `tscalectl up --resume %d` to continue provisioning or `tscalectl down %d` to delete them.", vpnNode.TscalectlID, vpnNode.TscalectlID)
+	if !resume {
+		rb.Add("node in CLI local state", func() { state.RemoveNode(cleanupCtx, vpnNode.TscalectlID) })
+	}
+
+	var privK *rsa.PrivateKey
+	var pubK ssh.PublicKey
+	if vpnNode.Done(state.StepSSHKeysCreated) {
+		privK = sshutil.LoadPrivateKey(name)
+
+		// earlier run may have stopped before the key pair was imported
+		var err error
+		pubK, err = ssh.NewPublicKey(&privK.PublicKey)
+		if err != nil {
+			panic(errors.Wrap(err, "up, public ssh key from rsa public key"))
+		}
+	}
+
+	var host string
+	instanceHost := func() string {
+		if host == "" {
+			inst := p.DescribeInstance(ctx, region, vpnNode.InstanceID)
+			vpnNode.PublicIP = inst.PublicIP
+			vpnNode.PrivateIP = inst.PrivateIP
+			state.UpdateNode(ctx, vpnNode)
+
+			host = p.SSHAddress(inst)
+		}
+		return host
+	}
+
+	steps := []step{
+		{
+			id:       state.StepSSHKeysCreated,
+			progress: "Creating CLI local SSH keys",
+			resource: "CLI local SSH keys",
+			do:       func() { privK, pubK = sshutil.CreateKeyPair(name) },
+			undo:     func() { sshutil.DeleteKeyPair(name) },
+		},
+		{
+			id:       state.StepKeyImported,
+			progress: "Importing EC2 key pair",
+			resource: "EC2 key pair",
+			do:       func() { vpnNode.KeyPairID = p.ImportKeyPair(ctx, region, name, pubK) },
+			undo:     func() { p.DeleteKeyPair(cleanupCtx, region, vpnNode.KeyPairID) },
+		},
+		{
+			id:       state.StepSecurityGroupCreated,
+			progress: "Creating EC2 security group",
+			resource: "EC2 security group",
+			do:       func() { vpnNode.SecurityGroupID = p.CreateSecurityGroup(ctx, region, name, !cloudInit) },
+			undo:     func() { p.DeleteSecurityGroup(cleanupCtx, region, vpnNode.SecurityGroupID) },
+		},
+		{
+			id:       state.StepInstanceLaunched,
+			progress: "Creating EC2 instance",
+			resource: "EC2 instance",
+			do: func() {
+				userData := ""
+				if cloudInit {
+					userData = cloudInitUserData(vpnNode)
+				}
+				inst := p.RunInstance(ctx, region, vpnNode.InstanceType, vpnNode.AMI, name, vpnNode.SecurityGroupID, userData)
+				vpnNode.InstanceID = inst.ID
+				vpnNode.AvailabilityZone = inst.AvailabilityZone
+				vpnNode.SubnetID = inst.SubnetID
+				vpnNode.PrivateIP = inst.PrivateIP
+			},
+			undo: func() {
+				p.TerminateInstance(cleanupCtx, region, vpnNode.InstanceID)
+				p.WaitForInstanceToTerminate(cleanupCtx, region, vpnNode.InstanceID)
+			},
+		},
+	}
+
+	if cloudInit {
+		steps = append(steps, step{
+			id:       state.StepTailscaleUp,
+			progress: "Waiting for cloud-init to start tailscale",
+			do: func() {
+				waitForCloudInit(ctx, p, region, vpnNode.InstanceID)
+
+				inst := p.DescribeInstance(ctx, region, vpnNode.InstanceID)
+				vpnNode.PublicIP = inst.PublicIP
+				vpnNode.PrivateIP = inst.PrivateIP
+			},
+		})
+	} else {
+		steps = append(steps, []step{
+			{
+				id:       state.StepKnownHostsUpdated,
+				progress: "Waiting for EC2 instance to boot",
+				do: func() {
+					p.WaitForInstanceToInitialize(ctx, region, vpnNode.InstanceID)
+					tsclog.Infof("Updating SSH known hosts")
+					sshutil.UpdateKnownHosts(ctx, privK, instanceHost())
+				},
+			},
+			{
+				id:       state.StepTailscaleInstalled,
+				progress: "Installing tailscale",
+				do:       func() { sshutil.InstallTailscale(ctx, privK, instanceHost()) },
+			},
+			{
+				id:       state.StepTailscaleUp,
+				progress: "Starting tailscale",
+				do: func() {
+					sshutil.TailscaleUp(ctx, privK, instanceHost(), creds.TailscaleAuthKey(), name, vpnNode.ExitNode)
+				},
+			},
+		}...)
+	}
+
+	for _, s := range steps {
+		s := s
+
+		// steps finished by earlier runs are kept
+		if vpnNode.Done(s.id) {
+			continue
+		}
+
+		interrupt.Check(ctx)
+		tsclog.Infof("%s", s.progress)
+		s.do()
+		vpnNode.CompleteStep(s.id)
+
+		// resource is recorded before the step is stored, so it is rolled back even if storing fails
+		if s.undo != nil {
+			rb.Add(s.resource, func() {
+				s.undo()
+				vpnNode.UndoStep(s.id)
+				state.UpdateNode(cleanupCtx, vpnNode)
+			})
+		}
+
+		state.UpdateNode(ctx, vpnNode)
+	}
+}
+
+func cloudInitUserData(vpnNode *state.VPNNode) string {
+	fragments := make([][]byte, 0, len(vpnNode.CloudConfigFiles))
+	for _, f := range vpnNode.CloudConfigFiles {
+		fragments = append(fragments, fileutil.ReadFile(f))
+	}
+
+	return cloudinit.UserData(creds.TailscaleAuthKey(), vpnNode.TscalectlName, vpnNode.ExitNode, fragments)
+}
+
+// waitForCloudInit waits for bootstrap marker in instance console output
+func waitForCloudInit(ctx context.Context, p provider.Provider, region string, instanceID string) {
+	startTime := time.Now()
+	for {
+		interrupt.Sleep(ctx, time.Second*5)
+
+		finished, err := cloudinit.Result(p.ConsoleOutput(ctx, region, instanceID))
+		if err != nil {
+			panic(errors.Wrap(err, "up, wait for cloud init"))
+		}
+		if finished {
+			tsclog.Infof("Cloud-init finished %s", time.Since(startTime))
+			return
+		}
+
+		if time.Since(startTime) > time.Minute*20 {
+			panic(tscerr.New(tscerr.Timeout, "up, wait for cloud init, timeout (no bootstrap marker in console output)"))
+		}
+		tsclog.Infof("Cloud-init still running, continuing to wait... %s", time.Since(startTime))
+	}
+}
@@ -1,15 +1,18 @@
 package up
 
 import (
-	"fmt"
+	"path/filepath"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/svennjegac/tailscale.node-provider/internal/aws/ec2cli"
 	"github.com/svennjegac/tailscale.node-provider/internal/creds"
-	"github.com/svennjegac/tailscale.node-provider/internal/sshutil"
+	"github.com/svennjegac/tailscale.node-provider/internal/output"
+	"github.com/svennjegac/tailscale.node-provider/internal/provider"
 	"github.com/svennjegac/tailscale.node-provider/internal/state"
 	"github.com/svennjegac/tailscale.node-provider/internal/trycatch"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
+	"github.com/svennjegac/tailscale.node-provider/internal/tsclog"
 	"github.com/svennjegac/tailscale.node-provider/internal/userinput"
 )
 
@@ -18,46 +21,90 @@ var exitNodeFlag bool
 var regionFlag string
 var instanceTypeFlag string
 var amiFlag string
+var providerFlag string
+var keepOnFailureFlag bool
+var resumeFlag int
+var bootstrapFlag string
+var cloudConfigFlag []string
 
 var UpCmd = &cobra.Command{
 	Use:   "up",
 	Short: "Create new tailscale node",
-	Long:  "Create new tailscale node with automatic authentication. There is no need for web approval.",
+	Long: "Create new tailscale node with automatic authentication. There is no need for web approval. " +
+		"json and yaml outputs are the created node with fields id, name, provider, region, availability_zone, instance_id, instance_type, ami, " +
+		"public_ip, private_ip, exit_node and bootstrap. " +
+		"With --bootstrap=cloud-init the tailscale auth key is part of the instance user data, which stays readable through instance metadata " +
+		"(169.254.169.254/latest/user-data) and EC2 DescribeInstanceAttribute for as long as the instance exists, " +
+		"so use a one-off or ephemeral auth key, or a pre-approved key limited to tags.",
 	// Args:  cobra.MinimumNArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) (runErr error) {
 		defer trycatch.ToError(&runErr)
 
-		// user interaction
-		region := userinput.Region(interactiveFlag, regionFlag)
-		instanceType := userinput.InstanceType(interactiveFlag, instanceTypeFlag, region)
-		ami := userinput.AMI(interactiveFlag, amiFlag, region)
+		ctx := cmd.Context()
 
-		// CLI internal state
-		vpnNode := state.AddNewNode(region, instanceType, ami)
+		var vpnNode *state.VPNNode
+		resume := cmd.Flags().Changed("resume")
+		if resume {
+			vpnNode = state.GetNode(ctx, resumeFlag)
+			if vpnNode.Done(state.StepTailscaleUp) {
+				panic(tscerr.Errorf(tscerr.BadInput, "up, node is already provisioned; node=%s", vpnNode.TscalectlName))
+			}
+			creds.Require(provider.New(vpnNode.Provider).NeedsCloudCredentials())
+		} else {
+			if bootstrapFlag != state.BootstrapSSH && bootstrapFlag != state.BootstrapCloudInit {
+				panic(tscerr.Errorf(tscerr.BadInput, "up, invalid bootstrap; bootstrap=%s, allowed-bootstraps=%+v", bootstrapFlag, []string{state.BootstrapSSH, state.BootstrapCloudInit}))
+			}
+			if len(cloudConfigFlag) > 0 && bootstrapFlag != state.BootstrapCloudInit {
+				panic(tscerr.New(tscerr.BadInput, "up, cloud config fragments can be used only with cloud-init bootstrap"))
+			}
 
-		fmt.Printf("Starting VPN node provisioning (%s, %s, %s)\n", region, instanceType, ami)
+			// files are read when instance is launched, which can be in `up --resume` started from other directory
+			for i, f := range cloudConfigFlag {
+				absPath, err := filepath.Abs(f)
+				if err != nil {
+					panic(errors.Wrap(err, "up, cloud config file absolute path"))
+				}
+				cloudConfigFlag[i] = absPath
+			}
 
-		privK, pubK := sshutil.CreateKeyPair(vpnNode.TscalectlName)
+			p := provider.New(providerFlag)
 
-		// AWS provisioning
-		fmt.Println("Importing EC2 key pair")
-		ec2cli.ImportKeyPair(region, vpnNode.TscalectlName, pubK)
-		fmt.Println("Creating EC2 security group")
-		securityGroupID := ec2cli.CreateSecurityGroup(region, vpnNode.TscalectlName)
-		fmt.Println("Creating EC2 instance")
-		ec2InstanceID := ec2cli.RunInstance(region, instanceType, ami, vpnNode.TscalectlName, securityGroupID)
-		fmt.Println("Waiting for EC2 instance to boot")
-		ec2cli.WaitForInstanceToInitialize(region, ec2InstanceID)
-		ec2InstancePublicIP := ec2cli.DescribeInstance(region, vpnNode.TscalectlName)
+			// credentials are asked for first, so prompts don't interrupt provisioning
+			creds.Require(p.NeedsCloudCredentials())
 
-		// starting tailscale on provisioned node
-		crd := creds.Get()
-		fmt.Println("Updating SSH known hosts")
-		sshutil.UpdateKnownHosts(privK, ec2InstancePublicIP)
-		fmt.Println("Starting tailscale")
-		sshutil.StartTailscale(privK, ec2InstancePublicIP, crd.TailscaleAuthKey, vpnNode.TscalectlName, exitNodeFlag)
+			// user interaction
+			region := userinput.Region(ctx, p, interactiveFlag, regionFlag)
+			instanceType := userinput.InstanceType(ctx, p, interactiveFlag, instanceTypeFlag, region)
+			ami := userinput.AMI(ctx, p, interactiveFlag, amiFlag, region)
 
-		fmt.Println("VPN node ready for use")
+			// CLI internal state
+			vpnNode = state.AddNewNode(ctx, &state.VPNNode{
+				Provider:         providerFlag,
+				Region:           region,
+				InstanceType:     instanceType,
+				AMI:              ami,
+				ExitNode:         exitNodeFlag,
+				Bootstrap:        bootstrapFlag,
+				CloudConfigFiles: cloudConfigFlag,
+			})
+		}
+
+		// new node starts a new log, resumed provisioning is appended to it
+		nodeLog := tsclog.OpenNode(vpnNode.TscalectlName, !resume)
+		defer nodeLog.Close()
+
+		if resume {
+			tsclog.Infof("Resuming VPN node provisioning (%s, %s, %s)", vpnNode.Region, vpnNode.InstanceType, vpnNode.AMI)
+		} else {
+			tsclog.Infof("Starting VPN node provisioning (%s, %s, %s)", vpnNode.Region, vpnNode.InstanceType, vpnNode.AMI)
+		}
+
+		provision(ctx, vpnNode, resume)
+
+		tsclog.Infof("VPN node ready for use")
+		if output.Structured() {
+			output.Result(newResult(vpnNode))
+		}
 
 		return nil
 	},
@@ -69,4 +116,46 @@ func init() {
 	UpCmd.Flags().StringVarP(&regionFlag, "region", "r", "", "Region in which VPN node should be created (AWS region, e.g. eu-west-1)")
 	UpCmd.Flags().StringVarP(&instanceTypeFlag, "instance-type", "t", "", "VPN node instance type (AWS instance type, e.g. t2.micro)")
 	UpCmd.Flags().StringVarP(&amiFlag, "ami", "a", "", "VPN node ami (AWS amazon machine image (OS))")
+	UpCmd.Flags().StringVar(&providerFlag, "provider", provider.AWS, "Cloud in which VPN node should be created (aws, or simulate for offline demos and tests)")
+	UpCmd.Flags().BoolVar(&keepOnFailureFlag, "keep-on-failure", false, "Do not delete already created resources when provisioning fails (useful for debugging)")
+	UpCmd.Flags().IntVar(&resumeFlag, "resume", 0, "Continue provisioning of the node with provided ID from its last finished step")
+	UpCmd.Flags().StringVar(&bootstrapFlag, "bootstrap", state.BootstrapSSH, "How tailscale is started on the node (ssh, or cloud-init which does not open SSH port to the internet, but leaves auth key in instance user data)")
+	UpCmd.Flags().StringArrayVar(&cloudConfigFlag, "cloud-config", nil, "Cloud-config file appended to the cloud-init user data (can be repeated, requires --bootstrap=cloud-init)")
+
+	for _, f := range []string{"interactive", "exit-node", "region", "instance-type", "ami", "provider", "bootstrap", "cloud-config"} {
+		UpCmd.MarkFlagsMutuallyExclusive("resume", f)
+	}
+}
+
+// result is the json and yaml output of up command
+type result struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name"`
+	Provider         string `json:"provider"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availability_zone"`
+	InstanceID       string `json:"instance_id"`
+	InstanceType     string `json:"instance_type"`
+	AMI              string `json:"ami"`
+	PublicIP         string `json:"public_ip"`
+	PrivateIP        string `json:"private_ip"`
+	ExitNode         bool   `json:"exit_node"`
+	Bootstrap        string `json:"bootstrap"`
+}
+
+func newResult(n *state.VPNNode) result {
+	return result{
+		ID:               n.TscalectlID,
+		Name:             n.TscalectlName,
+		Provider:         n.Provider,
+		Region:           n.Region,
+		AvailabilityZone: n.AvailabilityZone,
+		InstanceID:       n.InstanceID,
+		InstanceType:     n.InstanceType,
+		AMI:              n.AMI,
+		PublicIP:         n.PublicIP,
+		PrivateIP:        n.PrivateIP,
+		ExitNode:         n.ExitNode,
+		Bootstrap:        n.Bootstrap,
+	}
 }
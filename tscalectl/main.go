@@ -1,9 +1,25 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/svennjegac/tailscale.node-provider/internal/hint"
+	"github.com/svennjegac/tailscale.node-provider/internal/interrupt"
+	"github.com/svennjegac/tailscale.node-provider/internal/tscerr"
 	"github.com/svennjegac/tailscale.node-provider/tscalectl/commands"
 )
 
 func main() {
-	commands.RootCmd.Execute()
+	// first Ctrl-C cancels ctx, so commands stop and clean up, second one exits right away
+	ctx, stop := interrupt.NotifyContext(context.Background())
+
+	err := commands.RootCmd.ExecuteContext(ctx)
+	stop()
+
+	if h, ok := hint.For(err); ok {
+		fmt.Fprintln(os.Stderr, h)
+	}
+	os.Exit(tscerr.ExitCode(err))
 }